@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+func eventLogf(string) (func(string, ...any), func() error, error) {
+	return nil, nil, errors.New("the event log is only available on Windows")
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventLogf returns a log function writing to the Windows event log under
+// source, which must have been registered, and a function closing the log.
+func eventLogf(source string) (func(string, ...any), func() error, error) {
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("eventlog.Open: %w", err)
+	}
+	logf := func(format string, a ...any) {
+		l.Info(1, fmt.Sprintf(format, a...))
+	}
+	return logf, l.Close, nil
+}
@@ -5,17 +5,18 @@ go 1.21.3
 require (
 	github.com/fatih/color v1.15.0
 	github.com/fsnotify/fsnotify v1.7.0
+	github.com/rjeczalik/notify v0.9.3
 	github.com/urfave/cli/v2 v2.25.7
+	golang.org/x/mod v0.13.0
+	golang.org/x/sys v0.13.0
+	golang.org/x/term v0.13.0
 )
 
-require golang.org/x/mod v0.13.0 // indirect
-
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/tools v0.14.0
 )
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"marwan.io/gowatch/watcher"
+)
+
+// interactive runs the watcher with cfg, restarting the program when a line
+// reading "r" or "rs" is read from r and quitting when one reads "q".
+func interactive(ctx context.Context, cfg watcher.Config, r io.Reader) error {
+	if cfg.TUI {
+		return errors.New("the dashboard has its own keys, it cannot be used with --interactive")
+	}
+	if cfg.Stdin != nil {
+		return errors.New("the input of the program cannot be used with --interactive")
+	}
+	w, err := watcher.New(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The events are only used to know when the watcher stops on its own.
+	events := w.Events()
+	if err := w.Start(ctx); err != nil {
+		return err
+	}
+	go func() {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			switch strings.TrimSpace(s.Text()) {
+			case "r", "rs":
+				w.TriggerRestart()
+			case "q":
+				cancel()
+				return
+			}
+		}
+	}()
+	for range events {
+	}
+	return w.Stop()
+}
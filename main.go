@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
 	"marwan.io/gowatch/watcher"
 )
@@ -21,12 +28,83 @@ func main() {
 		Usage: "Automatically restart Go processes on file changes",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:  "cwd",
-				Usage: "set the current working directoy for the Go process",
+				Name:    "cwd",
+				Aliases: []string{"directory"},
+				Usage:   "set the current working directoy for the Go process",
+			},
+			&cli.StringFlag{
+				Name:  "package",
+				Usage: "the main package to build and run, such as ./cmd/api, the one in the working directory by default",
+			},
+			&cli.StringSliceFlag{
+				Name:    "additional-files",
+				Aliases: []string{"additiona-files"},
+				Usage:   "Comma separated directories or files to watch",
+			},
+			&cli.StringSliceFlag{
+				Name:  "asset-files",
+				Usage: "glob patterns of files whose changes run --on-asset-change instead of restarting",
+			},
+			&cli.StringSliceFlag{
+				Name:  "no-restart",
+				Usage: "glob patterns of files, such as templates/**/*.html, whose changes neither rebuild nor restart the program, only reloading the browsers with --proxy",
+			},
+			&cli.StringSliceFlag{
+				Name:  "restart-only",
+				Usage: "glob patterns of files, such as config/*.yaml or templates/**, whose changes restart the program without building it",
+			},
+			&cli.BoolFlag{
+				Name:  "no-color",
+				Usage: "print plain output without colors, such as when running without a console",
+			},
+			&cli.StringFlag{
+				Name:  "event-log",
+				Usage: "write the log to the Windows event log under this registered source",
+			},
+			&cli.StringFlag{
+				Name:  "crash-dir",
+				Usage: "directory where a report with the end of the output is saved when the program crashes",
+			},
+			&cli.IntFlag{
+				Name:  "crash-output-kb",
+				Usage: "kilobytes of output kept in crash reports",
+				Value: 64,
+			},
+			&cli.StringFlag{
+				Name:  "name",
+				Usage: "tag every line of the program output with a colored [name]",
+			},
+			&cli.StringFlag{
+				Name:  "summary-out",
+				Usage: "write a JSON summary of the cycles, failures and latencies to this file on exit",
+			},
+			&cli.StringFlag{
+				Name:  "attach",
+				Usage: "adopt a running program by pid or pid file instead of starting it, until the first change",
+			},
+			&cli.StringFlag{
+				Name:  "control-addr",
+				Usage: "serve an HTTP API to restart (POST /restart), stop (POST /stop), pause and resume (POST /pause, /resume) and inspect (/status, /files) gowatch on this address, such as 127.0.0.1:9999",
+			},
+			&cli.BoolFlag{
+				Name:  "interactive",
+				Usage: "restart the program when r or rs is entered, quit when q is entered",
+			},
+			&cli.BoolFlag{
+				Name:  "restart-on-interrupt",
+				Usage: "restart the program on Ctrl-C, quit on a second Ctrl-C within a second or on q",
+			},
+			&cli.BoolFlag{
+				Name:  "cgroup",
+				Usage: "run the program in its own cgroup to kill all its processes when it stops (Linux)",
 			},
 			&cli.StringSliceFlag{
-				Name:  "additiona-files",
-				Usage: "Comma separated directories or files to watch",
+				Name:  "sidecar",
+				Usage: "NAME=COMMAND of a helper process to run and restart alongside the program",
+			},
+			&cli.StringFlag{
+				Name:  "on-asset-change",
+				Usage: "command to run when an asset file changes, with its path in GOWATCH_FILE",
 			},
 			&cli.BoolFlag{
 				Name:  "vendor",
@@ -39,7 +117,284 @@ func main() {
 			&cli.BoolFlag{
 				Name:    "print-files",
 				Aliases: []string{"p"},
-				Usage:   "print all watched files",
+				Usage:   "print all watched files, see also the files command",
+			},
+			&cli.StringSliceFlag{
+				Name:  "build-env",
+				Usage: "KEY=VALUE pairs added to the environment of the build",
+			},
+			&cli.StringSliceFlag{
+				Name:  "run-env",
+				Usage: "KEY=VALUE pairs added to the environment of the program",
+			},
+			&cli.StringSliceFlag{
+				Name:  "env-allow",
+				Usage: "only pass these host environment variables to the program",
+			},
+			&cli.StringFlag{
+				Name:  "env-command",
+				Usage: "a command printing KEY=VALUE lines or JSON added to the environment of the program on every start",
+			},
+			&cli.StringFlag{
+				Name:  "go",
+				Usage: "the go command to build with",
+			},
+			&cli.StringFlag{
+				Name:  "cache-dir",
+				Usage: "a project-local directory for the GOCACHE and GOTMPDIR of the build",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-temp-dirs",
+				Usage: "do not remove the temporary directories of killed gowatch processes",
+			},
+			&cli.StringFlag{
+				Name:  "go-version",
+				Usage: "fail unless the go command provides this version",
+			},
+			&cli.StringSliceFlag{
+				Name:  "matrix",
+				Usage: "os/arch pairs to cross-compile after every successful build",
+			},
+			&cli.StringFlag{
+				Name:  "matrix-output",
+				Usage: "template of the cross-compiled binary paths",
+			},
+			&cli.StringSliceFlag{
+				Name:  "check",
+				Usage: "os/arch pairs to check that the program builds for after every successful build",
+			},
+			&cli.BoolFlag{
+				Name:  "skip-comments",
+				Usage: "do not restart when only comments or formatting change",
+			},
+			&cli.BoolFlag{
+				Name:  "progress",
+				Usage: "show a spinner while building",
+			},
+			&cli.BoolFlag{
+				Name:  "timings",
+				Usage: "log how long each phase of a restart took",
+			},
+			&cli.BoolFlag{
+				Name:  "quiet-build",
+				Usage: "only print the errors of failed builds",
+			},
+			&cli.BoolFlag{
+				Name:    "clear-screen",
+				Aliases: []string{"c"},
+				Usage:   "clear the terminal before every build",
+			},
+			&cli.BoolFlag{
+				Name:  "repeat-errors",
+				Usage: "repeat the build errors after long build outputs",
+			},
+			&cli.BoolFlag{
+				Name:  "resolve-modules",
+				Usage: "run the go get commands suggested by a failed build and retry it",
+			},
+			&cli.BoolFlag{
+				Name:  "auto-tidy",
+				Usage: "run 'go mod tidy' when a changed file imports a missing module",
+			},
+			&cli.BoolFlag{
+				Name:  "type-check",
+				Usage: "type check the changed package before building, skipping the build if it does not compile",
+			},
+			&cli.StringFlag{
+				Name:  "build",
+				Usage: "command used to build the program instead of 'go build'",
+			},
+			&cli.StringFlag{
+				Name:  "command",
+				Usage: "command to run after a successful build instead of the compiled binary",
+			},
+			&cli.StringFlag{
+				Name:  "proxy",
+				Usage: "address of a proxy to the program that reloads the browser after restarts, such as :8081, with --target",
+			},
+			&cli.StringSliceFlag{
+				Name:  "event-origin",
+				Usage: "an origin, such as chrome-extension://abcdefgh, whose pages may connect to the events WebSocket of the proxy, * for any",
+			},
+			&cli.StringFlag{
+				Name:  "target",
+				Usage: "address the program listens on, such as :8080, for --proxy",
+			},
+			&cli.IntSliceFlag{
+				Name:  "wait-port",
+				Usage: "a port the program listens on, such as 8080, that the previous program must release before it starts",
+			},
+			&cli.StringFlag{
+				Name:  "ready-check",
+				Usage: "a tcp://, http:// or grpc:// URL probed until it succeeds to report the program as ready, such as http://localhost:8080/healthz",
+			},
+			&cli.StringSliceFlag{
+				Name:  "pre-build",
+				Usage: "commands to run before every build, such as 'go generate ./...'",
+			},
+			&cli.StringSliceFlag{
+				Name:  "post-build",
+				Usage: "commands to run after every successful build, before the program starts",
+			},
+			&cli.StringFlag{
+				Name:  "exec",
+				Usage: "command to run on every change without building, such as a code generator",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "glob patterns of files not to watch, relative to the working directory, such as *_gen.go, **/mocks/*.go or testdata/",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude-dir",
+				Usage: "directories to exclude from watching",
+			},
+			&cli.StringSliceFlag{
+				Name:    "regex",
+				Aliases: []string{"r", "pattern"},
+				Usage:   "only watch files matching the regular expression",
+			},
+			&cli.StringSliceFlag{
+				Name:    "inverse-regex",
+				Aliases: []string{"R"},
+				Usage:   "do not watch files matching the regular expression",
+			},
+			&cli.BoolFlag{
+				Name:  "gitignore",
+				Value: true,
+				Usage: "skip files ignored by git, --gitignore=false watches them too",
+			},
+			&cli.BoolFlag{
+				Name:  "git-tracked",
+				Usage: "only watch files tracked by git",
+			},
+			&cli.StringFlag{
+				Name:  "record",
+				Usage: "record every file system event to the given file",
+			},
+			&cli.StringFlag{
+				Name:  "replay",
+				Usage: "replay the events recorded in the given file instead of watching files",
+			},
+			&cli.BoolFlag{
+				Name:  "highlight-panics",
+				Usage: "color panics and stack traces in the program's output",
+			},
+			&cli.StringFlag{
+				Name:  "grep",
+				Usage: "only print lines of the program's output matching the regular expression",
+			},
+			&cli.StringFlag{
+				Name:  "grep-v",
+				Usage: "do not print lines of the program's output matching the regular expression",
+			},
+			&cli.StringSliceFlag{
+				Name:  "highlight",
+				Usage: "color lines of the program's output, as pattern=style (e.g. ERROR=red+bold, DEBUG=dim)",
+			},
+			&cli.BoolFlag{
+				Name:  "diff-errors",
+				Usage: "mark which build errors are new and which were fixed since the last failed build",
+			},
+			&cli.StringSliceFlag{
+				Name:  "key",
+				Usage: "ACTION=KEY pairs remapping the keys of the dashboard, such as restart=R",
+			},
+			&cli.BoolFlag{
+				Name:  "pretty-errors",
+				Usage: "group build errors by file and color their positions",
+			},
+			&cli.BoolFlag{
+				Name:  "error-snippets",
+				Usage: "show the source line of every build error, with --pretty-errors",
+			},
+			&cli.StringFlag{
+				Name:  "log-format",
+				Usage: "format of the gowatch log on stderr: text or json",
+				Value: "text",
+			},
+			&cli.BoolFlag{
+				Name:  "tui",
+				Usage: "show a full screen dashboard instead of plain logs",
+			},
+			&cli.StringFlag{
+				Name:  "on-success",
+				Usage: "command to run when the build is fixed",
+			},
+			&cli.StringFlag{
+				Name:  "on-failure",
+				Usage: "command to run when the build breaks",
+			},
+			&cli.BoolFlag{
+				Name:  "pause-signal",
+				Usage: "toggle pausing restarts on SIGUSR1",
+			},
+			&cli.StringFlag{
+				Name:  "first-failure",
+				Usage: "what to do when the first build fails: watch, retry or exit",
+			},
+			&cli.BoolFlag{
+				Name:  "exit-on-first-failure",
+				Usage: "exit when the first build fails, same as --first-failure=exit",
+			},
+			&cli.StringFlag{
+				Name:  "signal",
+				Usage: "signal that stops the program, such as SIGTERM or SIGHUP, SIGINT by default",
+			},
+			&cli.DurationFlag{
+				Name:  "kill-timeout",
+				Usage: "kill the program if it did not exit this long after being interrupted, such as 5s",
+			},
+			&cli.DurationFlag{
+				Name:  "debounce",
+				Usage: "wait until files stopped changing for this long before restarting, such as 100ms",
+			},
+			&cli.BoolFlag{
+				Name:  "speculative-build",
+				Usage: "start building while --debounce waits for more changes, building again on every change",
+			},
+			&cli.DurationFlag{
+				Name:  "delay",
+				Usage: "wait until nothing was written in the watched directories for this long before rebuilding, such as 500ms for code generators",
+			},
+			&cli.DurationFlag{
+				Name:  "retry-interval",
+				Usage: "how often to retry a failed first build with --first-failure=retry",
+			},
+			&cli.BoolFlag{
+				Name:  "restart-on-crash",
+				Usage: "start the program again with an exponential backoff when it exits with an error",
+			},
+			&cli.IntFlag{
+				Name:  "max-crash-restarts",
+				Usage: "how many times in a row --restart-on-crash starts the program again, 5 by default",
+			},
+			&cli.DurationFlag{
+				Name:  "crash-backoff",
+				Usage: "how long --restart-on-crash waits before the first attempt, doubling for every next one, 500ms by default",
+			},
+			&cli.BoolFlag{
+				Name:  "once",
+				Usage: "build and run the program a single time, exiting with its status",
+			},
+			&cli.DurationFlag{
+				Name:  "usage-interval",
+				Usage: "log the watched files, kernel watches and event rate at this interval",
+			},
+			&cli.StringFlag{
+				Name:  "history",
+				Usage: "append every build and run cycle to this file",
+			},
+			&cli.StringFlag{
+				Name:  "backend",
+				Usage: "how file changes are detected: fsnotify, poll, watchman or notify",
+			},
+			&cli.BoolFlag{
+				Name:  "poll",
+				Usage: "poll the watched files instead of watching them, for network file systems and Docker volumes, same as --backend=poll",
+			},
+			&cli.DurationFlag{
+				Name:  "poll-interval",
+				Usage: "how often the poll backend checks for changes, selecting it unless --backend is set",
 			},
 		},
 		Commands: []*cli.Command{
@@ -47,15 +402,51 @@ func main() {
 				Name:  "init",
 				Usage: "creates a gowatch.json file in the current working directory",
 				Action: func(*cli.Context) error {
-					f, err := os.Create("gowatch.json")
+					b, err := configTemplate()
 					if err != nil {
 						return err
 					}
-					defer f.Close()
-					enc := json.NewEncoder(f)
-					enc.SetIndent("", "\t")
-					return enc.Encode(watcher.Config{})
+					return os.WriteFile(configFile, b, 0o644)
+				},
+			},
+			{
+				Name:  "files",
+				Usage: "prints the files gowatch watches",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the files as JSON, with their source and package",
+					},
+					&cli.BoolFlag{
+						Name:  "watch",
+						Usage: "keep printing the files added to and removed from the set",
+					},
+				},
+				Action: files,
+			},
+			{
+				Name:      "run",
+				Usage:     "builds and runs the main package, the one in the working directory by default",
+				ArgsUsage: "[package] [args]",
+				Action:    runPackage,
+			},
+			{
+				Name:      "test",
+				Usage:     "runs go test on the packages, ./... by default, on every change instead of running the program",
+				ArgsUsage: "[packages]",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "test-flags",
+						Usage: "flags passed to go test, such as -run=TestParse or -count=1",
+					},
 				},
+				Action: test,
+			},
+			{
+				Name:      "history",
+				Usage:     "prints the cycles recorded in a history file",
+				ArgsUsage: "[file]",
+				Action:    history,
 			},
 		},
 		Action: run,
@@ -69,34 +460,529 @@ func main() {
 const configFile = "gowatch.json"
 
 func run(c *cli.Context) error {
-	if _, err := os.Stat(configFile); err == nil {
-		return runFile(c.Context)
+	cfg, err := config(c)
+	if err != nil {
+		return err
 	}
-	return runCLI(c)
+	return start(c, cfg)
 }
 
-func runFile(ctx context.Context) error {
-	f, err := os.Open(configFile)
+// runPackage runs the main package given as the first argument, passing it
+// the other arguments.
+func runPackage(c *cli.Context) error {
+	cfg, err := config(c)
 	if err != nil {
-		return fmt.Errorf("configFile: %w", err)
+		return err
 	}
-	defer f.Close()
-	var c watcher.Config
-	err = json.NewDecoder(f).Decode(&c)
+	if c.Args().Present() {
+		cfg.Package = c.Args().First()
+		cfg.RuntimeArgs = c.Args().Tail()
+	}
+	return start(c, cfg)
+}
+
+// test runs go test instead of the program, on the packages given as
+// arguments if any.
+func test(c *cli.Context) error {
+	cfg, err := config(c)
 	if err != nil {
-		return fmt.Errorf("json.Decode: %w", err)
+		return err
+	}
+	cfg.Mode = watcher.ModeTest
+	if c.Args().Present() {
+		cfg.TestPackages = c.Args().Slice()
 	}
-	return watcher.Run(ctx, c)
+	cfg.TestFlags = append(cfg.TestFlags, c.StringSlice("test-flags")...)
+	return start(c, cfg)
 }
 
-func runCLI(c *cli.Context) error {
-	cfg := watcher.Config{
-		Dir:             c.String("cwd"),
-		AdditionalFiles: c.StringSlice("additional-files"),
-		BuildFlags:      c.StringSlice("build-flag"),
-		RuntimeArgs:     c.Args().Slice(),
-		Vendor:          c.Bool("vendor"),
-		PrintFiles:      c.Bool("print-files"),
+// start runs the watcher with cfg and the settings of the command line that
+// apply regardless of the config file.
+func start(c *cli.Context, cfg watcher.Config) error {
+	if err := applyUserConfig(&cfg); err != nil {
+		return err
+	}
+	if c.Bool("no-color") {
+		color.NoColor = true
+	}
+	if source := c.String("event-log"); source != "" {
+		logf, closeLog, err := eventLogf(source)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+		// The event log shows escape sequences as is.
+		color.NoColor = true
+		cfg.Logf = logf
+	}
+	if cfg.RestartOnInterrupt && !cfg.Once {
+		// The watcher handles interrupts itself.
+		signal.Ignore(os.Interrupt)
+	}
+	if c.Bool("interactive") {
+		return interactive(c.Context, cfg, os.Stdin)
 	}
 	return watcher.Run(c.Context, cfg)
 }
+
+// config returns the configuration of gowatch.json if it exists, with the
+// flags given on the command line overriding its fields, and the one of the
+// command line flags otherwise.
+func config(c *cli.Context) (watcher.Config, error) {
+	flags := cliConfig(c)
+	if _, err := os.Stat(configFile); err != nil {
+		return flags, nil
+	}
+	cfg, err := fileConfig()
+	if err != nil {
+		return cfg, err
+	}
+	dst, src := reflect.ValueOf(&cfg).Elem(), reflect.ValueOf(flags)
+	for flag, field := range flagFields {
+		if c.IsSet(flag) {
+			dst.FieldByName(field).Set(src.FieldByName(field))
+		}
+	}
+	if c.Args().Present() {
+		cfg.RuntimeArgs = flags.RuntimeArgs
+	}
+	return cfg, nil
+}
+
+// flagFields maps the flags of cliConfig to the field of watcher.Config they
+// set, for the flags given on the command line to override gowatch.json.
+var flagFields = map[string]string{
+	"cwd":                   "Dir",
+	"package":               "Package",
+	"name":                  "Name",
+	"additional-files":      "AdditionalFiles",
+	"asset-files":           "AssetFiles",
+	"on-asset-change":       "OnAssetChange",
+	"restart-only":          "RestartOnly",
+	"no-restart":            "NoRestart",
+	"sidecar":               "Sidecars",
+	"cgroup":                "Cgroup",
+	"restart-on-interrupt":  "RestartOnInterrupt",
+	"attach":                "Attach",
+	"summary-out":           "SummaryOut",
+	"crash-dir":             "CrashDir",
+	"crash-output-kb":       "CrashOutputSize",
+	"build-flags":           "BuildFlags",
+	"vendor":                "Vendor",
+	"print-files":           "PrintFiles",
+	"build-env":             "BuildEnv",
+	"run-env":               "RunEnv",
+	"env-allow":             "EnvAllowlist",
+	"env-command":           "EnvFromCommand",
+	"go":                    "GoBinary",
+	"go-version":            "GoVersion",
+	"cache-dir":             "CacheDir",
+	"keep-temp-dirs":        "KeepStaleTempDirs",
+	"matrix":                "Matrix",
+	"matrix-output":         "MatrixOutput",
+	"check":                 "CheckBuilds",
+	"skip-comments":         "SkipCommentChanges",
+	"progress":              "Progress",
+	"timings":               "Timings",
+	"quiet-build":           "QuietBuild",
+	"repeat-errors":         "RepeatErrors",
+	"clear-screen":          "ClearScreen",
+	"resolve-modules":       "ResolveModules",
+	"auto-tidy":             "AutoTidy",
+	"type-check":            "TypeCheck",
+	"build":                 "Build",
+	"exec":                  "Exec",
+	"pre-build":             "PreBuild",
+	"proxy":                 "Proxy",
+	"target":                "ProxyTarget",
+	"event-origin":          "EventOrigins",
+	"wait-port":             "WaitForPorts",
+	"ready-check":           "ReadyCheck",
+	"control-addr":          "ControlAddr",
+	"post-build":            "PostBuild",
+	"command":               "Command",
+	"exclude-dir":           "ExcludeDirs",
+	"exclude":               "ExcludePatterns",
+	"regex":                 "Include",
+	"inverse-regex":         "Exclude",
+	"git-tracked":           "GitTracked",
+	"gitignore":             "UseGitignore",
+	"record":                "Record",
+	"replay":                "Replay",
+	"highlight-panics":      "HighlightPanics",
+	"grep":                  "Grep",
+	"grep-v":                "GrepV",
+	"highlight":             "Highlight",
+	"diff-errors":           "DiffBuildErrors",
+	"key":                   "Keys",
+	"pretty-errors":         "PrettyBuildErrors",
+	"error-snippets":        "ErrorSnippets",
+	"tui":                   "TUI",
+	"log-format":            "LogFormat",
+	"on-success":            "OnSuccess",
+	"on-failure":            "OnFailure",
+	"pause-signal":          "PauseSignal",
+	"first-failure":         "OnFirstFailure",
+	"exit-on-first-failure": "OnFirstFailure",
+	"retry-interval":        "RetryInterval",
+	"restart-on-crash":      "RestartOnCrash",
+	"max-crash-restarts":    "MaxCrashRestarts",
+	"crash-backoff":         "CrashBackoff",
+	"kill-timeout":          "KillTimeout",
+	"signal":                "StopSignal",
+	"debounce":              "Debounce",
+	"delay":                 "RestartDelay",
+	"speculative-build":     "SpeculativeBuild",
+	"once":                  "Once",
+	"usage-interval":        "UsageInterval",
+	"history":               "History",
+	"backend":               "Backend",
+	"poll":                  "Backend",
+	"poll-interval":         "PollInterval",
+}
+
+// userConfigFile is the path, relative to os.UserConfigDir, of the settings
+// that apply to every project.
+const userConfigFile = "gowatch/config.json"
+
+// userConfig holds the settings of userConfigFile.
+type userConfig struct {
+	// Keys remaps the keys of the dashboard, see watcher.Config.Keys.
+	Keys map[string]string
+}
+
+// applyUserConfig fills in the settings of userConfigFile that cfg leaves
+// unset.
+func applyUserConfig(cfg *watcher.Config) error {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil
+	}
+	path := filepath.Join(dir, userConfigFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var u userConfig
+	if err := json.Unmarshal(standardizeJSON(b), &u); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for action, key := range u.Keys {
+		if _, ok := cfg.Keys[action]; ok {
+			continue
+		}
+		if cfg.Keys == nil {
+			cfg.Keys = map[string]string{}
+		}
+		cfg.Keys[action] = key
+	}
+	return nil
+}
+
+// fileConfig reads gowatch.json, which may contain comments and trailing
+// commas.
+func fileConfig() (watcher.Config, error) {
+	var c watcher.Config
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		return c, fmt.Errorf("configFile: %w", err)
+	}
+	err = json.Unmarshal(standardizeJSON(b), &c)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", configFile, err)
+	}
+	return c, nil
+}
+
+// templateComments are written above the fields of the gowatch.json created
+// by init.
+var templateComments = map[string]string{
+	"Dir":             "directory of the main package, the current directory by default",
+	"Package":         "the main package to build and run, such as ./cmd/api, relative to Dir",
+	"AdditionalFiles": "glob patterns of other files whose changes restart the program",
+	"BuildFlags":      "flags passed to go build, such as -race or -tags=dev",
+	"RuntimeArgs":     "arguments passed to the program",
+	"AssetFiles":      "glob patterns of files that run OnAssetChange instead of restarting",
+	"RestartOnly":     "glob patterns of files whose changes restart the program without building it",
+	"NoRestart":       "glob patterns of files, such as templates parsed on every request, whose changes do not restart the program",
+	"RunEnv":          "KEY=VALUE pairs added to the environment of the program",
+	"BuildEnv":        "KEY=VALUE pairs added to the environment of the build",
+	"Build":           "a command run instead of go build",
+	"Command":         "a command run instead of the built program",
+	"ExcludeDirs":     "directory names or glob patterns whose files are not watched",
+	"Backend":         "how changes are detected: fsnotify, poll, watchman or notify",
+	"TUI":             "show a full screen dashboard instead of the log",
+	"Targets":         "several programs to watch, each with a Name, Dir, Package, BuildFlags, RuntimeArgs, Env, DependsOn and ReadyCheck",
+}
+
+// configTemplate returns the default config as JSON, with comments
+// describing the main fields.
+func configTemplate() ([]byte, error) {
+	b, err := json.MarshalIndent(watcher.Config{}, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	out.WriteString("// gowatch configuration. Comments and trailing commas are allowed,\n")
+	out.WriteString("// see gowatch --help for the flag matching every field.\n")
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		field, _, ok := strings.Cut(strings.TrimSpace(line), `":`)
+		if comment := templateComments[strings.TrimPrefix(field, `"`)]; ok && comment != "" {
+			fmt.Fprintf(&out, "\t// %s\n", comment)
+		}
+		out.WriteString(line)
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+func cliConfig(c *cli.Context) watcher.Config {
+	firstFailure := c.String("first-failure")
+	if c.Bool("exit-on-first-failure") {
+		firstFailure = watcher.FirstFailureExit
+	}
+	backend := c.String("backend")
+	if c.Bool("poll") && backend == "" {
+		backend = "poll"
+	}
+	var gitignore *bool
+	if c.IsSet("gitignore") {
+		use := c.Bool("gitignore")
+		gitignore = &use
+	}
+	return watcher.Config{
+		Dir:                c.String("cwd"),
+		Package:            c.String("package"),
+		Name:               c.String("name"),
+		AdditionalFiles:    c.StringSlice("additional-files"),
+		AssetFiles:         c.StringSlice("asset-files"),
+		OnAssetChange:      c.String("on-asset-change"),
+		RestartOnly:        c.StringSlice("restart-only"),
+		NoRestart:          c.StringSlice("no-restart"),
+		Sidecars:           sidecars(c.StringSlice("sidecar")),
+		Cgroup:             c.Bool("cgroup"),
+		RestartOnInterrupt: c.Bool("restart-on-interrupt"),
+		Attach:             c.String("attach"),
+		SummaryOut:         c.String("summary-out"),
+		CrashDir:           c.String("crash-dir"),
+		CrashOutputSize:    c.Int("crash-output-kb") << 10,
+		BuildFlags:         c.StringSlice("build-flags"),
+		RuntimeArgs:        c.Args().Slice(),
+		Vendor:             c.Bool("vendor"),
+		PrintFiles:         c.Bool("print-files"),
+		BuildEnv:           c.StringSlice("build-env"),
+		RunEnv:             c.StringSlice("run-env"),
+		EnvAllowlist:       c.StringSlice("env-allow"),
+		EnvFromCommand:     c.String("env-command"),
+		GoBinary:           c.String("go"),
+		GoVersion:          c.String("go-version"),
+		CacheDir:           c.String("cache-dir"),
+		KeepStaleTempDirs:  c.Bool("keep-temp-dirs"),
+		Matrix:             c.StringSlice("matrix"),
+		MatrixOutput:       c.String("matrix-output"),
+		CheckBuilds:        c.StringSlice("check"),
+		SkipCommentChanges: c.Bool("skip-comments"),
+		Progress:           c.Bool("progress"),
+		Timings:            c.Bool("timings"),
+		QuietBuild:         c.Bool("quiet-build"),
+		RepeatErrors:       c.Bool("repeat-errors"),
+		ClearScreen:        c.Bool("clear-screen"),
+		ResolveModules:     c.Bool("resolve-modules"),
+		AutoTidy:           c.Bool("auto-tidy"),
+		TypeCheck:          c.Bool("type-check"),
+		Build:              c.String("build"),
+		Exec:               c.String("exec"),
+		PreBuild:           c.StringSlice("pre-build"),
+		Proxy:              c.String("proxy"),
+		ProxyTarget:        c.String("target"),
+		EventOrigins:       c.StringSlice("event-origin"),
+		WaitForPorts:       c.IntSlice("wait-port"),
+		ReadyCheck:         c.String("ready-check"),
+		ControlAddr:        c.String("control-addr"),
+		PostBuild:          c.StringSlice("post-build"),
+		Command:            c.String("command"),
+		ExcludeDirs:        c.StringSlice("exclude-dir"),
+		ExcludePatterns:    c.StringSlice("exclude"),
+		Include:            c.StringSlice("regex"),
+		Exclude:            c.StringSlice("inverse-regex"),
+		GitTracked:         c.Bool("git-tracked"),
+		UseGitignore:       gitignore,
+		Record:             c.String("record"),
+		Replay:             c.String("replay"),
+		HighlightPanics:    c.Bool("highlight-panics"),
+		Grep:               c.String("grep"),
+		GrepV:              c.String("grep-v"),
+		Highlight:          highlightRules(c.StringSlice("highlight")),
+		DiffBuildErrors:    c.Bool("diff-errors"),
+		Keys:               keyBindings(c.StringSlice("key")),
+		PrettyBuildErrors:  c.Bool("pretty-errors"),
+		ErrorSnippets:      c.Bool("error-snippets"),
+		TUI:                c.Bool("tui"),
+		LogFormat:          c.String("log-format"),
+		OnSuccess:          c.String("on-success"),
+		OnFailure:          c.String("on-failure"),
+		PauseSignal:        c.Bool("pause-signal"),
+		OnFirstFailure:     firstFailure,
+		RetryInterval:      c.Duration("retry-interval"),
+		RestartOnCrash:     c.Bool("restart-on-crash"),
+		MaxCrashRestarts:   c.Int("max-crash-restarts"),
+		CrashBackoff:       c.Duration("crash-backoff"),
+		KillTimeout:        c.Duration("kill-timeout"),
+		StopSignal:         c.String("signal"),
+		Debounce:           c.Duration("debounce"),
+		RestartDelay:       c.Duration("delay"),
+		SpeculativeBuild:   c.Bool("speculative-build"),
+		Once:               c.Bool("once"),
+		UsageInterval:      c.Duration("usage-interval"),
+		History:            c.String("history"),
+		Backend:            backend,
+		PollInterval:       c.Duration("poll-interval"),
+	}
+}
+
+// files prints the watched files, one path per line or as JSON. With
+// --watch, it then prints the changes to the set as they happen: lines
+// prefixed with + or -, or JSON objects with added and removed fields.
+func files(c *cli.Context) error {
+	cfg, err := config(c)
+	if err != nil {
+		return err
+	}
+	asJSON := c.Bool("json")
+	if !c.Bool("watch") {
+		list, err := watcher.ListFiles(cfg)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			return enc.Encode(list)
+		}
+		for _, f := range list {
+			fmt.Println(f.Path)
+		}
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	first := true
+	return watcher.WatchFiles(c.Context, cfg, func(added, removed []watcher.File) {
+		switch {
+		case asJSON:
+			enc.Encode(struct {
+				Added   []watcher.File `json:"added,omitempty"`
+				Removed []watcher.File `json:"removed,omitempty"`
+			}{added, removed})
+		case first:
+			for _, f := range added {
+				fmt.Println(f.Path)
+			}
+		default:
+			for _, f := range added {
+				fmt.Println("+ " + f.Path)
+			}
+			for _, f := range removed {
+				fmt.Println("- " + f.Path)
+			}
+		}
+		first = false
+	})
+}
+
+// history prints the cycles of the history file given as argument, or of
+// the one set in gowatch.json, followed by the trigger files whose changes
+// broke the build.
+func history(c *cli.Context) error {
+	path := c.Args().First()
+	if _, err := os.Stat(configFile); path == "" && err == nil {
+		cfg, err := fileConfig()
+		if err != nil {
+			return err
+		}
+		if len(cfg.Targets) > 0 && cfg.History != "" {
+			return errors.New("every target has a history file, with its name before the extension of History: pass the one of a target")
+		}
+		path = cfg.History
+	}
+	if path == "" {
+		return errors.New("no history file: pass one or set History in gowatch.json")
+	}
+	cycles, err := watcher.ReadHistory(path)
+	if err != nil {
+		return fmt.Errorf("readHistory: %w", err)
+	}
+	failures, totals := map[string]int{}, map[string]int{}
+	for _, cycle := range cycles {
+		fmt.Println(cycle)
+		if cycle.Trigger == "" {
+			continue
+		}
+		totals[cycle.Trigger]++
+		if cycle.Result == watcher.ResultBuildFailed {
+			failures[cycle.Trigger]++
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	triggers := make([]string, 0, len(failures))
+	for trigger := range failures {
+		triggers = append(triggers, trigger)
+	}
+	sort.Slice(triggers, func(i, j int) bool {
+		return failures[triggers[i]] > failures[triggers[j]]
+	})
+	fmt.Println("\nbuild failures by trigger:")
+	for _, trigger := range triggers {
+		fmt.Printf("  %s: %d of %d builds failed\n", trigger, failures[trigger], totals[trigger])
+	}
+	return nil
+}
+
+// sidecars parses NAME=COMMAND pairs. A sidecar without a name is named
+// after its command.
+func sidecars(specs []string) []watcher.Sidecar {
+	var sidecars []watcher.Sidecar
+	for _, spec := range specs {
+		name, command, ok := strings.Cut(spec, "=")
+		if !ok || strings.ContainsAny(name, " \t") {
+			fields := strings.Fields(spec)
+			if len(fields) == 0 {
+				continue
+			}
+			name, command = filepath.Base(fields[0]), spec
+		}
+		sidecars = append(sidecars, watcher.Sidecar{Name: name, Command: command})
+	}
+	return sidecars
+}
+
+// keyBindings parses ACTION=KEY pairs.
+func keyBindings(specs []string) map[string]string {
+	if len(specs) == 0 {
+		return nil
+	}
+	keys := map[string]string{}
+	for _, spec := range specs {
+		action, key, _ := strings.Cut(spec, "=")
+		keys[action] = key
+	}
+	return keys
+}
+
+// highlightRules parses pattern=style pairs given on the command line.
+func highlightRules(specs []string) []watcher.HighlightRule {
+	rules := make([]watcher.HighlightRule, 0, len(specs))
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, "=")
+		if i < 0 {
+			rules = append(rules, watcher.HighlightRule{Pattern: spec, Style: "bold"})
+			continue
+		}
+		rules = append(rules, watcher.HighlightRule{Pattern: spec[:i], Style: spec[i+1:]})
+	}
+	return rules
+}
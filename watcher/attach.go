@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attachPollInterval is how often an adopted process is checked for exit.
+const attachPollInterval = 100 * time.Millisecond
+
+// adoptedProcess is a Process gowatch did not start. Its exit status cannot
+// be known, Wait returns nil once it is gone.
+type adoptedProcess struct {
+	p     *os.Process
+	clock Clock
+}
+
+// attach adopts the process of Config.Attach, a pid or a file containing
+// one.
+func attach(target string, clock Clock) (*adoptedProcess, error) {
+	s := target
+	if _, err := strconv.Atoi(s); err != nil {
+		b, err := os.ReadFile(target)
+		if err != nil {
+			return nil, fmt.Errorf("reading pid file: %w", err)
+		}
+		s = strings.TrimSpace(string(b))
+	}
+	pid, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pid %q", s)
+	}
+	if !processAlive(pid) {
+		return nil, fmt.Errorf("no process with pid %d", pid)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, fmt.Errorf("os.FindProcess: %w", err)
+	}
+	return &adoptedProcess{p: p, clock: clock}, nil
+}
+
+func (a *adoptedProcess) Signal(sig os.Signal) error {
+	if sig == os.Interrupt {
+		return interrupt(a.p)
+	}
+	return a.p.Signal(sig)
+}
+
+func (a *adoptedProcess) Pid() int {
+	return a.p.Pid
+}
+
+func (a *adoptedProcess) Wait() error {
+	for processAlive(a.p.Pid) {
+		<-a.clock.After(attachPollInterval)
+	}
+	return nil
+}
+
+// adopt makes p the running program, as if it had been started by
+// startBinary.
+func (w *watcher) adopt(p *adoptedProcess) {
+	w.c.Logf("attached to process %d, it is replaced on the first change", p.p.Pid)
+	w.proc = p
+	w.tui.processStarted()
+	w.control.processStarted(p)
+	go func() {
+		w.exitChan <- p.Wait()
+	}()
+}
@@ -0,0 +1,224 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Backend reports changes to the files added to it. Adding a directory
+// reports the files created, written and removed directly inside it.
+type Backend interface {
+	Add(name string) error
+	Remove(name string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]func(Config) (Backend, error){
+		"fsnotify": func(Config) (Backend, error) { return newFsnotifyBackend() },
+		"poll":     func(c Config) (Backend, error) { return newPollBackend(c.PollInterval, c.Clock), nil },
+		"watchman": func(Config) (Backend, error) { return newWatchmanBackend() },
+		"notify":   func(Config) (Backend, error) { return newNotifyBackend(), nil },
+	}
+)
+
+// RegisterBackend makes a backend available under name so that it can be
+// selected with Config.Backend. It panics if a backend with the same name
+// is already registered.
+func RegisterBackend(name string, newBackend func(Config) (Backend, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic("watcher: RegisterBackend called twice for backend " + name)
+	}
+	backends[name] = newBackend
+}
+
+func newBackend(c Config) (Backend, error) {
+	if c.Changes != nil {
+		return c.Changes, nil
+	}
+	if c.Replay != "" {
+		return newReplayBackend(c.Replay, c.Clock, c.Logf), nil
+	}
+	name := c.Backend
+	if name == "" {
+		name = "fsnotify"
+	}
+	backendsMu.Lock()
+	fn, ok := backends[name]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q, available backends: %v", name, backendNames())
+	}
+	return fn(c)
+}
+
+func backendNames() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fsnotifyBackend is the default Backend, backed by fsnotify. It watches
+// the directories of the added files, so that a file replaced by an editor
+// on save stays watched, and reports the events of the added files and of
+// the files directly inside the added directories.
+type fsnotifyBackend struct {
+	w      *fsnotify.Watcher
+	events chan fsnotify.Event
+	done   chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	added map[string]string // pathKey of the added paths to their name
+	dirs  map[string]bool   // pathKey of the added directories
+	// watched counts the added paths of every watched directory.
+	watched map[string]int
+	raw     func(fsnotify.Event) // see recordRaw
+}
+
+// rawBackend is implemented by the backends that drop some of the events
+// they receive, such as the ones of the other files of the watched
+// directories. recordRaw makes them pass every event they receive to fn
+// first, so that Config.Record writes the raw events.
+type rawBackend interface {
+	recordRaw(fn func(fsnotify.Event))
+}
+
+func (b *fsnotifyBackend) recordRaw(fn func(fsnotify.Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.raw = fn
+}
+
+// addedName returns the name the event of a file named name is reported
+// with, and whether it is reported: the file is one of added, or is directly
+// inside one of dirs. The maps are keyed by pathKey.
+func addedName(added map[string]string, dirs map[string]bool, name string) (string, bool) {
+	if added, ok := added[pathKey(name)]; ok {
+		return added, true
+	}
+	return name, dirs[pathKey(filepath.Dir(name))]
+}
+
+func newFsnotifyBackend() (*fsnotifyBackend, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("fsnotify.NewWatcher: %w", err)
+	}
+	b := &fsnotifyBackend{
+		w:       w,
+		events:  make(chan fsnotify.Event),
+		done:    make(chan struct{}),
+		added:   map[string]string{},
+		dirs:    map[string]bool{},
+		watched: map[string]int{},
+	}
+	go b.forward()
+	return b, nil
+}
+
+func (b *fsnotifyBackend) Add(name string) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	key := pathKey(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.added[key]; ok {
+		return nil
+	}
+	dir := name
+	if !fi.IsDir() {
+		dir = filepath.Dir(name)
+	}
+	if b.watched[pathKey(dir)] == 0 {
+		if err := b.w.Add(dir); err != nil {
+			return err
+		}
+	}
+	b.watched[pathKey(dir)]++
+	b.added[key] = name
+	b.dirs[key] = fi.IsDir()
+	return nil
+}
+
+func (b *fsnotifyBackend) Remove(name string) error {
+	key := pathKey(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.added[key]; !ok {
+		return nil
+	}
+	dir := name
+	if !b.dirs[key] {
+		dir = filepath.Dir(name)
+	}
+	delete(b.added, key)
+	delete(b.dirs, key)
+	b.watched[pathKey(dir)]--
+	if b.watched[pathKey(dir)] > 0 {
+		return nil
+	}
+	delete(b.watched, pathKey(dir))
+	return b.w.Remove(dir)
+}
+
+func (b *fsnotifyBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *fsnotifyBackend) Errors() <-chan error          { return b.w.Errors }
+
+func (b *fsnotifyBackend) Close() error {
+	var err error
+	b.once.Do(func() {
+		close(b.done)
+		err = b.w.Close()
+	})
+	return err
+}
+
+// forward sends the events of the added paths, and of the files inside the
+// added directories, to b.events.
+func (b *fsnotifyBackend) forward() {
+	for {
+		var event fsnotify.Event
+		select {
+		case <-b.done:
+			return
+		case e, ok := <-b.w.Events:
+			if !ok {
+				return
+			}
+			event = e
+		}
+		b.mu.Lock()
+		raw := b.raw
+		name, ok := addedName(b.added, b.dirs, event.Name)
+		b.mu.Unlock()
+		if raw != nil {
+			raw(event)
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case <-b.done:
+			return
+		case b.events <- fsnotify.Event{Name: name, Op: event.Op}:
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// runCommands runs commands one after the other, streaming their output to
+// Stdout and Stderr, and stops at the first one that fails.
+func (w *watcher) runCommands(ctx context.Context, stage string, commands []string) error {
+	for _, command := range commands {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		w.c.Logf("running %s command: %s", stage, command)
+		err := w.c.Runner.Run(ctx, Cmd{
+			Name:   fields[0],
+			Args:   fields[1:],
+			Dir:    w.c.Dir,
+			Env:    append(os.Environ(), w.c.BuildEnv...),
+			Stdout: w.c.Stdout,
+			Stderr: w.c.Stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("%s command %q: %w", stage, command, err)
+		}
+	}
+	return nil
+}
+
+// preBuild runs the PreBuild commands and records the content of the
+// watched files they wrote, such as generated code, so that these writes
+// do not start another cycle.
+func (w *watcher) preBuild(ctx context.Context) error {
+	if len(w.c.PreBuild) == 0 {
+		return nil
+	}
+	before := w.modTimes()
+	err := w.runCommands(ctx, "pre-build", w.c.PreBuild)
+	w.generated = map[string][sha256.Size]byte{}
+	for key, mtime := range w.modTimes() {
+		if before[key].Equal(mtime) {
+			continue
+		}
+		if data, err := w.c.FS.ReadFile(w.files[key]); err == nil {
+			w.generated[key] = sha256.Sum256(data)
+		}
+	}
+	return err
+}
+
+// modTimes returns the modification times of the watched files.
+func (w *watcher) modTimes() map[string]time.Time {
+	times := make(map[string]time.Time, len(w.files))
+	for key, path := range w.files {
+		if fi, err := os.Stat(path); err == nil {
+			times[key] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+// writtenByPreBuild reports whether the file at path still has the content the
+// PreBuild commands wrote.
+func (w *watcher) writtenByPreBuild(path string) bool {
+	sum, ok := w.generated[pathKey(path)]
+	if !ok {
+		return false
+	}
+	data, err := w.c.FS.ReadFile(path)
+	return err == nil && sha256.Sum256(data) == sum
+}
@@ -0,0 +1,28 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// isolateCache points the GOCACHE and GOTMPDIR of the build environment at
+// the "gocache" and "tmp" directories of c.CacheDir, resolved relative to
+// c.Dir, creating them if needed.
+func isolateCache(c *Config) error {
+	if c.CacheDir == "" {
+		return nil
+	}
+	dir := c.CacheDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.Dir, dir)
+	}
+	cache, tmp := filepath.Join(dir, "gocache"), filepath.Join(dir, "tmp")
+	for _, d := range []string{cache, tmp} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return fmt.Errorf("os.MkdirAll: %w", err)
+		}
+	}
+	c.BuildEnv = append(c.BuildEnv, "GOCACHE="+cache, "GOTMPDIR="+tmp)
+	return nil
+}
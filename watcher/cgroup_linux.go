@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroup is a cgroup v2 the program is started in, so that every process it
+// spawns, even daemonized ones, can be killed when it stops.
+type cgroup struct {
+	dir string
+}
+
+var cgroupSeq atomic.Int64
+
+// newCgroup creates a cgroup below the one of gowatch.
+func newCgroup() (*cgroup, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return nil, err
+	}
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	var parent string
+	for _, line := range strings.Split(string(self), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			parent = path
+		}
+	}
+	if parent == "" {
+		return nil, errors.New("gowatch is not in a cgroup v2")
+	}
+	name := fmt.Sprintf("gowatch-%d-%d", os.Getpid(), cgroupSeq.Add(1))
+	dir := filepath.Join(mount, parent, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating cgroup: %w", err)
+	}
+	return &cgroup{dir: dir}, nil
+}
+
+// cgroup2Mount returns where the cgroup v2 hierarchy is mounted.
+func cgroup2Mount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// The fields after the " - " separator are the file system type,
+		// the source and the super block options.
+		before, after, ok := strings.Cut(s.Text(), " - ")
+		fields := strings.Fields(before)
+		if ok && strings.HasPrefix(after, "cgroup2 ") && len(fields) > 4 {
+			return fields[4], nil
+		}
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// start starts cmd in the cgroup.
+func (g *cgroup) start(cmd *exec.Cmd) error {
+	f, err := os.Open(g.dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return cmd.Start()
+}
+
+// close kills the processes left in the cgroup and removes it.
+func (g *cgroup) close() error {
+	if err := os.WriteFile(filepath.Join(g.dir, "cgroup.kill"), []byte("1"), 0); err != nil {
+		// cgroup.kill needs Linux 5.14, kill the processes one by one
+		// on older kernels.
+		g.killProcs()
+	}
+	// The cgroup can only be removed once its processes are gone.
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(g.dir); err == nil || !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+		g.killProcs()
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing cgroup: %w", err)
+	}
+	return nil
+}
+
+func (g *cgroup) killProcs() {
+	b, err := os.ReadFile(filepath.Join(g.dir, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, field := range strings.Fields(string(b)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// usage reads the memory and CPU time used by the processes of the cgroup.
+func (g *cgroup) usage() (ResourceUsage, bool) {
+	var u ResourceUsage
+	current, err := readCgroupValue(filepath.Join(g.dir, "memory.current"))
+	if err != nil {
+		// The memory controller is not enabled for the cgroup.
+		return u, false
+	}
+	u.Memory = current
+	// memory.peak needs Linux 5.19.
+	u.PeakMemory, _ = readCgroupValue(filepath.Join(g.dir, "memory.peak"))
+	stat, err := os.ReadFile(filepath.Join(g.dir, "cpu.stat"))
+	if err != nil {
+		return u, false
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if usec, ok := strings.CutPrefix(line, "usage_usec "); ok {
+			n, _ := strconv.ParseUint(usec, 10, 64)
+			u.CPU = time.Duration(n) * time.Microsecond
+		}
+	}
+	return u, true
+}
+
+// readCgroupValue reads the cgroup file of a single number.
+func readCgroupValue(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,36 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCgroupUsage(t *testing.T) {
+	dir := t.TempDir()
+	g := &cgroup{dir: dir}
+	if _, ok := g.usage(); ok {
+		t.Fatal("usage of a cgroup without the memory controller is reported")
+	}
+	for name, content := range map[string]string{
+		"memory.current": "3145728\n",
+		"memory.peak":    "4194304\n",
+		"cpu.stat":       "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, ok := g.usage()
+	if !ok {
+		t.Fatal("usage is not reported")
+	}
+	want := ResourceUsage{Memory: 3 << 20, PeakMemory: 4 << 20, CPU: 1500 * time.Millisecond}
+	if u != want {
+		t.Errorf("usage = %+v, want %+v", u, want)
+	}
+	if got, want := u.String(), "3.0 MiB of memory (peak 4.0 MiB), 1.5s of CPU"; got != want {
+		t.Errorf("usage = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package watcher
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type cgroup struct{}
+
+func newCgroup() (*cgroup, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func (g *cgroup) start(cmd *exec.Cmd) error { return cmd.Start() }
+func (g *cgroup) close() error              { return nil }
+
+func (g *cgroup) usage() (ResourceUsage, bool) { return ResourceUsage{}, false }
@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"path/filepath"
+
+	"github.com/fatih/color"
+)
+
+// change is what a write to a watched file calls for.
+type change int
+
+const (
+	changeNone      change = iota // nothing, such as a comment edit
+	changeAsset                   // running OnAssetChange
+	changeNoRestart               // only reporting it
+	changeRestart                 // restarting the program without building it
+	changeImports                 // discovering the files again and rebuilding
+	changeModule                  // the same, for a go.mod or go.work file
+	changeBuild                   // rebuilding and restarting the program
+)
+
+// classify returns what a write to the watched file name calls for,
+// logging why it is skipped if it calls for nothing.
+func (w *watcher) classify(name string) change {
+	key := pathKey(name)
+	if _, ok := w.assets[key]; ok {
+		return changeAsset
+	}
+	if _, ok := w.noRestart[key]; ok {
+		return changeNoRestart
+	}
+	if _, ok := w.restartOnly[key]; ok {
+		return changeRestart
+	}
+	if w.writtenByPreBuild(name) {
+		return changeNone
+	}
+	if _, ok := w.unimported[key]; ok {
+		w.c.Logf(color.MagentaString("%v is not imported by the program, skipping rebuild", name))
+		return changeNone
+	}
+	if base := filepath.Base(name); base == "go.mod" || base == "go.work" {
+		// Its requirements, replace and use directives decide which
+		// modules the files are watched in.
+		return changeModule
+	}
+	if w.codeHashes != nil && !w.codeChanged(name) {
+		w.c.Logf(color.MagentaString("only comments changed in %v, skipping restart", name))
+		return changeNone
+	}
+	if w.importsChanged(name) {
+		return changeImports
+	}
+	return changeBuild
+}
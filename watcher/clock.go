@@ -0,0 +1,15 @@
+package watcher
+
+import "time"
+
+// Clock tells the time. It is used for everything time related in the
+// watcher so that tests and embedders can control it.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// codeChanged reports whether the code of the Go file at path changed since
+// the last call, ignoring comments and formatting. Files that are not Go
+// files, or that were not hashed yet, are reported as changed.
+func (w *watcher) codeChanged(path string) bool {
+	if !strings.HasSuffix(path, ".go") {
+		return true
+	}
+	sum, ok := codeHash(w.c.FS, path)
+	if !ok {
+		delete(w.codeHashes, pathKey(path))
+		return true
+	}
+	old, seen := w.codeHashes[pathKey(path)]
+	w.codeHashes[pathKey(path)] = sum
+	return !seen || old != sum
+}
+
+// hashCode records the code hash of every watched Go file.
+func (w *watcher) hashCode() {
+	w.codeHashes = map[string][sha256.Size]byte{}
+	for key, path := range w.files {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		if sum, ok := codeHash(w.c.FS, path); ok {
+			w.codeHashes[key] = sum
+		}
+	}
+}
+
+// codeHash hashes the tokens of the Go file at path in fsys. Comments are
+// left out, except for directives such as //go:build or //go:embed that
+// change the build. Files that import "C" hash their comments too, as the
+// cgo preamble is a comment.
+func codeHash(fsys FS, path string) ([sha256.Size]byte, bool) {
+	src, err := fsys.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+	if strings.Contains(string(src), `"C"`) {
+		return sha256.Sum256(src), true
+	}
+	fset := token.NewFileSet()
+	file := fset.AddFile(path, -1, len(src))
+	var s scanner.Scanner
+	var failed bool
+	s.Init(file, src, func(token.Position, string) { failed = true }, scanner.ScanComments)
+	h := sha256.New()
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch {
+		case tok == token.COMMENT && !isDirective(lit):
+			continue
+		case tok == token.SEMICOLON && lit == "\n":
+			// Automatic semicolons are hashed the same as explicit ones.
+			lit = ";"
+		}
+		h.Write([]byte(tok.String()))
+		h.Write([]byte{0})
+		h.Write([]byte(lit))
+		h.Write([]byte{0})
+	}
+	if failed {
+		return [sha256.Size]byte{}, false
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, true
+}
+
+func isDirective(comment string) bool {
+	return strings.HasPrefix(comment, "//go:") || strings.HasPrefix(comment, "// +build") ||
+		strings.HasPrefix(comment, "//line ") || strings.HasPrefix(comment, "/*line ") ||
+		strings.HasPrefix(comment, "//export ")
+}
@@ -0,0 +1,319 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the state of the program reported by the /status endpoint of
+// the control API.
+type Status struct {
+	// Name is the name of the target, with several Targets.
+	Name string `json:"name,omitempty"`
+	// State is one of the State constants.
+	State string `json:"state"`
+	// PID is the process id of the running program, if known.
+	PID int `json:"pid,omitempty"`
+	// LastBuild is when the last build finished, nil before the first
+	// one, and LastBuildDuration how long it took.
+	LastBuild         *time.Time    `json:"lastBuild,omitempty"`
+	LastBuildDuration time.Duration `json:"lastBuildDuration"`
+	// Paused is set while restarts are paused.
+	Paused bool `json:"paused"`
+	// Error is why the last build failed or why the program exited.
+	Error string `json:"error,omitempty"`
+	// Usage is the resources used by the running program, with Cgroup.
+	Usage *ResourceUsage `json:"usage,omitempty"`
+	// History is the last cycles of the program, oldest first.
+	History []Cycle `json:"history,omitempty"`
+}
+
+// The states of a Status.
+const (
+	StateStarting    = "starting"
+	StateBuilding    = "building"
+	StateBuildFailed = "build failed"
+	StateRunning     = "running"
+	StateExited      = "exited"
+	StateStopped     = "stopped"
+)
+
+// controlServer serves the control API of ControlAddr, which lets scripts
+// and editors restart or stop a running gowatch and query its status:
+//
+//	POST /restart  rebuilds and restarts the program
+//	POST /stop     stops the program and gowatch
+//	POST /pause    pauses restarts, the changes are still recorded
+//	POST /resume   resumes restarts, rebuilding once if files changed
+//	GET  /status   returns the Status as a JSON array of one element
+//	GET  /files    returns the watched files as a JSON array
+//
+// With several Targets, a single server serves all of them: the POST
+// endpoints apply to every target, /status returns the Status of every
+// target and /files the files of all of them, unless the target query
+// parameter selects one by its name.
+type controlServer struct {
+	server *http.Server
+
+	mu      sync.Mutex
+	targets []*control
+}
+
+// control is the state of one program served by a controlServer.
+type control struct {
+	name     string
+	commands chan<- tuiCommand
+	clock    Clock
+	history  *history
+	done     chan struct{}
+
+	mu         sync.Mutex
+	status     Status
+	buildStart time.Time
+	files      []string
+	proc       Process // the running program
+}
+
+func newControlServer(addr string, logf func(string, ...any)) (*controlServer, error) {
+	s := &controlServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/restart", s.command(cmdRestart))
+	mux.HandleFunc("/stop", s.command(cmdQuit))
+	mux.HandleFunc("/pause", s.command(cmdPause))
+	mux.HandleFunc("/resume", s.command(cmdResume))
+	mux.HandleFunc("/status", s.serveStatus)
+	mux.HandleFunc("/files", s.serveFiles)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+	s.server = &http.Server{Handler: mux}
+	go s.server.Serve(ln)
+	logf("control API listening on http://%s", ln.Addr())
+	return s, nil
+}
+
+// add serves the program named name, whose watch loop receives commands
+// and records its cycles in h, until the returned control is closed.
+func (s *controlServer) add(name string, commands chan<- tuiCommand, clock Clock, h *history) *control {
+	c := &control{
+		name:     name,
+		commands: commands,
+		clock:    clock,
+		history:  h,
+		done:     make(chan struct{}),
+		status:   Status{Name: name, State: StateStarting},
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets = append(s.targets, c)
+	sort.Slice(s.targets, func(i, j int) bool { return s.targets[i].name < s.targets[j].name })
+	return c
+}
+
+func (s *controlServer) close() {
+	s.server.Close()
+}
+
+// selected returns the targets a request applies to, writing an error if
+// it names an unknown one.
+func (s *controlServer) selected(w http.ResponseWriter, r *http.Request) ([]*control, bool) {
+	s.mu.Lock()
+	targets := s.targets
+	s.mu.Unlock()
+	name := r.URL.Query().Get("target")
+	if name == "" {
+		return targets, true
+	}
+	for _, c := range targets {
+		if c.name == name {
+			return []*control{c}, true
+		}
+	}
+	http.Error(w, fmt.Sprintf("no target %q", name), http.StatusNotFound)
+	return nil, false
+}
+
+// command returns a handler sending cmd to the watch loops of the selected
+// targets.
+func (s *controlServer) command(cmd tuiCommand) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		targets, ok := s.selected(w, r)
+		if !ok {
+			return
+		}
+		sent := 0
+		for _, c := range targets {
+			select {
+			case c.commands <- cmd:
+				sent++
+			case <-c.done:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if sent == 0 {
+			http.Error(w, "gowatch is stopping", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *controlServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	targets, ok := s.selected(w, r)
+	if !ok {
+		return
+	}
+	statuses := make([]Status, len(targets))
+	for i, c := range targets {
+		c.mu.Lock()
+		statuses[i] = c.status
+		proc := c.proc
+		c.mu.Unlock()
+		if u, ok := resourceUsage(proc); ok {
+			statuses[i].Usage = &u
+		}
+		statuses[i].History = c.history.snapshot()
+	}
+	writeJSON(w, statuses)
+}
+
+func (s *controlServer) serveFiles(w http.ResponseWriter, r *http.Request) {
+	targets, ok := s.selected(w, r)
+	if !ok {
+		return
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for _, c := range targets {
+		c.mu.Lock()
+		for _, file := range c.files {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+		c.mu.Unlock()
+	}
+	if len(targets) > 1 {
+		sort.Strings(files)
+	}
+	writeJSON(w, files)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(v)
+}
+
+// close stops serving the program of c, the server keeps serving the
+// other ones.
+func (c *control) close() {
+	if c == nil {
+		return
+	}
+	close(c.done)
+}
+
+func (c *control) setFiles(files []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files = files
+}
+
+func (c *control) buildStarted() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buildStart = c.clock.Now()
+	c.status.State = StateBuilding
+}
+
+func (c *control) buildFinished(output string, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	c.status.LastBuild = &now
+	c.status.LastBuildDuration = now.Sub(c.buildStart)
+	c.status.Error = ""
+	if err != nil {
+		c.status.Error = firstLine(output)
+		if c.status.Error == "" {
+			c.status.Error = err.Error()
+		}
+		c.status.State = StateBuildFailed
+	} else if c.status.PID != 0 {
+		// The previous program runs until the new one replaces it.
+		c.status.State = StateRunning
+	}
+}
+
+func (c *control) setPaused(paused bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.Paused = paused
+}
+
+func (c *control) processStarted(p Process) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.State, c.status.PID, c.status.Error = StateRunning, pid(p), ""
+	c.proc = p
+}
+
+// processExited records that the program exited with err, or that it was
+// stopped by gowatch if stopped is set.
+func (c *control) processExited(err error, stopped bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.PID, c.proc = 0, nil
+	if c.status.State != StateRunning {
+		return
+	}
+	c.status.State = StateExited
+	if stopped {
+		c.status.State = StateStopped
+	}
+	if err != nil {
+		c.status.Error = err.Error()
+	}
+}
+
+// pid returns the process id of p, or 0 if it does not have one. A Process
+// of a custom Runner can report it with a Pid method.
+func pid(p Process) int {
+	if p, ok := p.(interface{ Pid() int }); ok {
+		return p.Pid()
+	}
+	return 0
+}
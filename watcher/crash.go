@@ -0,0 +1,95 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCrashOutputSize = 64 << 10
+
+// tailBuffer keeps the last bytes written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	if size <= 0 {
+		size = defaultCrashOutputSize
+	}
+	return &tailBuffer{size: size}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*t.size {
+		// Copy the tail so that the buffer does not grow forever.
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-t.size:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.buf) > t.size {
+		return string(t.buf[len(t.buf)-t.size:])
+	}
+	return string(t.buf)
+}
+
+// crashRecord is what is needed to report a crash of the running program.
+type crashRecord struct {
+	cmd     Cmd
+	started time.Time
+	output  *tailBuffer
+}
+
+// saveCrash writes a crash report for the program that exited with err to
+// CrashDir, with the end of its output.
+func (w *watcher) saveCrash(err error) {
+	r := w.crash
+	if r == nil {
+		return
+	}
+	w.crash = nil
+	dir := w.c.CrashDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(w.c.Dir, dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		w.c.Logf("error writing crash report: %v", err)
+		return
+	}
+	now := w.c.Clock.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "error:   %v\n", err)
+	fmt.Fprintf(&b, "started: %s\n", r.started.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "exited:  %s\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "command: %s\n", strings.Join(append([]string{r.cmd.Name}, r.cmd.Args...), " "))
+	if out, err := git(w.c.Dir, "rev-parse", "HEAD"); err == nil {
+		fmt.Fprintf(&b, "git:     %s\n", strings.TrimSpace(string(out)))
+	}
+	// Only the names of the variables are recorded, their values may be
+	// secrets.
+	names := make([]string, 0, len(r.cmd.Env))
+	for _, kv := range r.cmd.Env {
+		name, _, _ := strings.Cut(kv, "=")
+		names = append(names, name)
+	}
+	fmt.Fprintf(&b, "env:     %s\n", strings.Join(uniq(names), " "))
+	fmt.Fprintf(&b, "\n--- last %d bytes of output ---\n%s", r.output.size, r.output)
+	path := filepath.Join(dir, "crash-"+now.Format("20060102-150405.000")+".log")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		w.c.Logf("error writing crash report: %v", err)
+		return
+	}
+	w.c.Logf("crash report written to %s", path)
+}
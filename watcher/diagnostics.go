@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Diagnostic is an error reported by the Go compiler. Column is 0 when the
+// compiler did not report one.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// key identifies a diagnostic across builds. Line numbers are left out
+// because they shift as code is edited above the error.
+func (d Diagnostic) key() string {
+	return d.File + ": " + d.Message
+}
+
+var diagnosticRE = regexp.MustCompile(`^(.+?\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// parseDiagnostic parses a line of go build output such as
+// "./main.go:12:3: undefined: foo".
+func parseDiagnostic(line string) (Diagnostic, bool) {
+	m := diagnosticRE.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if m == nil {
+		return Diagnostic{}, false
+	}
+	d := Diagnostic{File: m[1], Message: m[4]}
+	d.Line, _ = strconv.Atoi(m[2])
+	d.Column, _ = strconv.Atoi(m[3])
+	return d, true
+}
+
+// parseDiagnostics returns the errors of a go build output.
+func parseDiagnostics(output string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		if d, ok := parseDiagnostic(line); ok {
+			diags = append(diags, d)
+		}
+	}
+	return diags
+}
+
+// repeatErrorsAfter is the number of lines of build output after which the
+// errors are repeated with RepeatErrors.
+const repeatErrorsAfter = 20
+
+// goProgress matches the progress messages of the go command.
+var goProgress = regexp.MustCompile(`^go: (downloading|finding|extracting|found|added|upgraded|downgraded) `)
+
+// errorBlock returns the lines of a build output that are not progress
+// messages of the go command.
+func errorBlock(output string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line == "" || goProgress.MatchString(line) {
+			continue
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// formatDiagnostics rewrites the errors of a build output grouped by file,
+// with colored paths and positions. Lines that are not errors, such as
+// "too many errors", are kept after the groups and "# package" headers are
+// dropped. With snippets, every error is followed by its source line, read
+// relative to dir, and a caret under the column.
+func formatDiagnostics(output, dir string, snippets bool) string {
+	type entry struct {
+		d     Diagnostic
+		notes []string // indented continuation lines
+	}
+	var (
+		files  []string
+		groups = map[string][]*entry{}
+		other  []string
+		last   *entry
+	)
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if d, ok := parseDiagnostic(line); ok {
+			if _, ok := groups[d.File]; !ok {
+				files = append(files, d.File)
+			}
+			last = &entry{d: d}
+			groups[d.File] = append(groups[d.File], last)
+			continue
+		}
+		switch {
+		case line == "" || strings.HasPrefix(line, "# "):
+		case last != nil && strings.HasPrefix(line, "\t"):
+			last.notes = append(last.notes, strings.TrimSpace(line))
+		default:
+			last = nil
+			other = append(other, line)
+		}
+	}
+	if len(files) == 0 {
+		return output
+	}
+
+	var b strings.Builder
+	fileColor := color.New(color.FgCyan, color.Bold)
+	posColor := color.New(color.FgYellow)
+	faint := color.New(color.Faint)
+	for _, file := range files {
+		fmt.Fprintln(&b, fileColor.Sprint(file))
+		var lines []string
+		if snippets {
+			lines = readLines(file, dir)
+		}
+		for _, e := range groups[file] {
+			pos := strconv.Itoa(e.d.Line)
+			if e.d.Column > 0 {
+				pos += ":" + strconv.Itoa(e.d.Column)
+			}
+			fmt.Fprintf(&b, "  %s  %s\n", posColor.Sprintf("%-7s", pos), e.d.Message)
+			for _, note := range e.notes {
+				fmt.Fprintf(&b, "           %s\n", faint.Sprint(note))
+			}
+			if e.d.Line > 0 && e.d.Line <= len(lines) {
+				src := strings.ReplaceAll(lines[e.d.Line-1], "\t", "    ")
+				fmt.Fprintf(&b, "           %s\n", faint.Sprint(src))
+				if e.d.Column > 0 {
+					// Tabs before the column were expanded to four spaces.
+					prefix := lines[e.d.Line-1]
+					if e.d.Column-1 < len(prefix) {
+						prefix = prefix[:e.d.Column-1]
+					}
+					width := len(prefix) + 3*strings.Count(prefix, "\t")
+					fmt.Fprintf(&b, "           %s%s\n", strings.Repeat(" ", width), color.RedString("^"))
+				}
+			}
+		}
+	}
+	for _, line := range other {
+		fmt.Fprintln(&b, line)
+	}
+	return b.String()
+}
+
+// readLines returns the lines of file, relative to dir, or nil if it cannot
+// be read.
+func readLines(file, dir string) []string {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(b), "\n")
+}
@@ -0,0 +1,244 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Watcher runs the watch loop of a Config in the background, for programs
+// that embed gowatch, such as editor plugins and test harnesses, and drive
+// its restarts themselves. Run is the blocking equivalent.
+type Watcher struct {
+	w     *watcher
+	flush func()
+
+	mu      sync.Mutex
+	started bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+	err     error
+	subs    []chan Event
+	closed  bool
+}
+
+// Event is something that happened in a Watcher. Only the payload fields of
+// its Type are set.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// File is the changed file of an EventFileChanged.
+	File string
+	// Build is the result of an EventBuildSucceeded or EventBuildFailed,
+	// and of the last build before an EventProcessStarted, which is the one
+	// of the program unless it is not built, such as in the test mode.
+	Build BuildResult
+	// Pid is the process id of the program of an EventProcessStarted, if
+	// its Runner reports it.
+	Pid int
+	// Err is why the program of an EventProcessExited exited, nil if it
+	// exited successfully.
+	Err error
+	// Panic is the panic of an EventPanic, with its file and line.
+	Panic Panic
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+// The types of the events of a Watcher.
+const (
+	EventFileChanged EventType = iota
+	EventBuildStarted
+	EventBuildSucceeded
+	EventBuildFailed
+	EventProcessStarted
+	EventProcessExited
+	// EventPanic is sent for the panics found in the output of the
+	// program with HighlightPanics.
+	EventPanic
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventFileChanged:
+		return "file changed"
+	case EventBuildStarted:
+		return "build started"
+	case EventBuildSucceeded:
+		return "build succeeded"
+	case EventBuildFailed:
+		return "build failed"
+	case EventProcessStarted:
+		return "process started"
+	case EventProcessExited:
+		return "process exited"
+	case EventPanic:
+		return "panic"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// eventBuffer is how many events a Watcher keeps for a slow subscriber
+// before it drops them.
+const eventBuffer = 64
+
+// New validates c and returns a Watcher for it. The callbacks of c are
+// still called, in addition to the events being sent. Targets are only
+// supported by Run.
+func New(c Config) (*Watcher, error) {
+	if len(c.Targets) > 0 {
+		return nil, errors.New("a Watcher runs a single program, use Run for Targets")
+	}
+	flush := func() {}
+	if c.Name != "" {
+		flush = c.tagOutput(tag(c.Name, 0, len(c.Name)))
+	}
+	ww := &Watcher{flush: flush}
+	onFileChange, onBuildStart, onBuild, onStart, onExit, onPanic := c.OnFileChange, c.OnBuildStart, c.OnBuild, c.OnProcessStart, c.OnProcessExit, c.OnPanic
+	c.OnFileChange = func(file string) {
+		ww.send(Event{Type: EventFileChanged, File: file})
+		if onFileChange != nil {
+			onFileChange(file)
+		}
+	}
+	c.OnBuildStart = func() {
+		ww.send(Event{Type: EventBuildStarted})
+		if onBuildStart != nil {
+			onBuildStart()
+		}
+	}
+	c.OnBuild = func(r BuildResult) {
+		e := Event{Type: EventBuildSucceeded, Build: r}
+		if !r.Success {
+			e.Type = EventBuildFailed
+		}
+		ww.send(e)
+		if onBuild != nil {
+			onBuild(r)
+		}
+	}
+	c.OnProcessStart = func() {
+		ww.send(Event{Type: EventProcessStarted, Build: ww.w.lastBuild, Pid: pid(ww.w.proc)})
+		if onStart != nil {
+			onStart()
+		}
+	}
+	c.OnProcessExit = func(err error) {
+		ww.send(Event{Type: EventProcessExited, Err: err})
+		if onExit != nil {
+			onExit(err)
+		}
+	}
+	c.OnPanic = func(p Panic) {
+		ww.send(Event{Type: EventPanic, Panic: p})
+		if onPanic != nil {
+			onPanic(p)
+		}
+	}
+	w, err := newWatcher(c)
+	if err != nil {
+		return nil, err
+	}
+	w.commands = make(chan tuiCommand)
+	w.embedded = true
+	ww.w = w
+	return ww, nil
+}
+
+// send stamps e and sends it to every subscriber without blocking the
+// watch loop, dropping it for the subscribers that are too far behind.
+func (ww *Watcher) send(e Event) {
+	e.Time = ww.w.c.Clock.Now()
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	for _, sub := range ww.subs {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// Events subscribes to the events of the watcher and returns the channel
+// they are sent to, starting with the next one. Every call returns a new
+// channel, so that several consumers receive every event. The channels are
+// closed once the watcher stops, and events are dropped while a channel is
+// full.
+func (ww *Watcher) Events() <-chan Event {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	sub := make(chan Event, eventBuffer)
+	if ww.closed {
+		close(sub)
+		return sub
+	}
+	ww.subs = append(ww.subs, sub)
+	return sub
+}
+
+// Start builds and starts the program, and watches its files in the
+// background until ctx is done or Stop is called. A Watcher can only be
+// started once.
+func (ww *Watcher) Start(ctx context.Context) error {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	if ww.started {
+		return errors.New("the watcher was already started")
+	}
+	ww.started = true
+	ctx, ww.cancel = context.WithCancel(ctx)
+	ww.done = make(chan struct{})
+	go func() {
+		err := ww.w.run(ctx)
+		ww.flush()
+		ww.mu.Lock()
+		ww.err = err
+		ww.closed = true
+		for _, sub := range ww.subs {
+			close(sub)
+		}
+		ww.subs = nil
+		ww.mu.Unlock()
+		close(ww.done)
+	}()
+	return nil
+}
+
+// TriggerRestart rebuilds and restarts the program as if one of its files
+// changed. It does nothing if the watcher is not running.
+func (ww *Watcher) TriggerRestart() {
+	ww.mu.Lock()
+	done := ww.done
+	ww.mu.Unlock()
+	if done == nil {
+		return
+	}
+	select {
+	case ww.w.commands <- cmdRestart:
+	case <-done:
+	}
+}
+
+// Stop stops the program and the watcher, waits for them to exit and
+// returns the error the watcher stopped with, if any. Being stopped is not
+// an error.
+func (ww *Watcher) Stop() error {
+	ww.mu.Lock()
+	cancel, done := ww.cancel, ww.done
+	ww.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	if errors.Is(ww.err, context.Canceled) {
+		// The program was interrupted because of the cancellation.
+		return nil
+	}
+	return ww.err
+}
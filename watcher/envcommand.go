@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// commandEnv runs EnvFromCommand and returns the variables it printed, either
+// as KEY=VALUE lines or as a JSON object of strings.
+func (w *watcher) commandEnv(ctx context.Context) ([]string, error) {
+	var stdout bytes.Buffer
+	fields := strings.Fields(w.c.EnvFromCommand)
+	err := w.c.Runner.Run(ctx, Cmd{
+		Name:   fields[0],
+		Args:   fields[1:],
+		Dir:    w.c.Dir,
+		Env:    os.Environ(),
+		Stdout: &stdout,
+		Stderr: w.c.Stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseEnv(stdout.Bytes())
+}
+
+// parseEnv parses a JSON object of strings, or KEY=VALUE lines in the format
+// of env files: blank lines and lines starting with # are skipped, and a
+// leading "export" and quotes around the value are removed.
+func parseEnv(b []byte) ([]string, error) {
+	b = bytes.TrimSpace(b)
+	if bytes.HasPrefix(b, []byte("{")) {
+		var vars map[string]string
+		if err := json.Unmarshal(b, &vars); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		env := make([]string, 0, len(vars))
+		for k, v := range vars {
+			env = append(env, k+"="+v)
+		}
+		sort.Strings(env)
+		return env, nil
+	}
+	var env []string
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", i+1)
+		}
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		env = append(env, strings.TrimSpace(k)+"="+v)
+	}
+	return env, nil
+}
@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// ExitError describes how the program exited. It is the error passed to
+// OnProcessExit when the program fails.
+type ExitError struct {
+	// Code is the exit code of the program, or 128 plus the signal number
+	// if it was killed by a signal, as shells report it.
+	Code int
+	// Signal is the signal that killed the program, if any.
+	Signal syscall.Signal
+	// Hint explains the usual cause of the exit code or signal, if known.
+	Hint string
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	var s string
+	if e.Signal != 0 {
+		s = fmt.Sprintf("killed by signal %d (%v)", int(e.Signal), e.Signal)
+	} else {
+		s = fmt.Sprintf("exit code %d", e.Code)
+	}
+	if e.Hint != "" {
+		s += ": " + e.Hint
+	}
+	return s
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// ExitCode returns e.Code, so that the command line exits with the status of
+// the program in once mode.
+func (e *ExitError) ExitCode() int { return e.Code }
+
+// exitHints maps common exit codes to their usual cause.
+var exitHints = map[int]string{
+	2:   "the program panicked or hit a fatal runtime error",
+	126: "the command is not executable",
+	127: "the command was not found",
+	130: "interrupted (SIGINT)",
+	137: "killed (SIGKILL), possibly by the out of memory killer",
+	143: "terminated (SIGTERM)",
+}
+
+// describeExit turns the error of a process that exited with a nonzero
+// status into an *ExitError, and returns other errors unchanged.
+func describeExit(err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	e := &ExitError{Code: exitErr.ExitCode(), Err: err}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		e.Signal = ws.Signal()
+		e.Code = 128 + int(e.Signal)
+	}
+	e.Hint = exitHints[e.Code]
+	return e
+}
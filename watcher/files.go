@@ -0,0 +1,215 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// File is a watched file.
+type File struct {
+	Path string `json:"path"`
+	// Source is how the file was found, one of the Source constants.
+	Source string `json:"source"`
+	// Package is the import path of the package the file belongs to, if
+	// any.
+	Package string `json:"package,omitempty"`
+}
+
+// The sources of a File.
+const (
+	SourcePackage = "package" // a Go file of the program
+	SourceEmbed   = "embed"   // a file embedded in the program
+	SourceVendor  = "vendor"  // a Go file of a vendored package
+	SourceGlob    = "glob"    // a file matched by AdditionalFiles
+	SourceAsset   = "asset"   // a file matched by AssetFiles
+	SourceRestart = "restart" // a file matched by RestartOnly
+	SourceLive    = "live"    // a file matched by NoRestart
+	SourceModule  = "module"  // a go.mod, go.sum or go.work file
+	SourceOther   = "other"   // a non Go file of a package, such as .s or .c
+)
+
+// ListFiles returns the files Run watches with c, sorted by path.
+func ListFiles(c Config) ([]File, error) {
+	w, err := newWatcher(c)
+	if err != nil {
+		return nil, err
+	}
+	return w.listFiles()
+}
+
+// WatchFiles calls fn with the files Run watches with c, then, every time a
+// watched file changes, with the files that were added to and removed from
+// the set since, until ctx is done.
+func WatchFiles(ctx context.Context, c Config, fn func(added, removed []File)) error {
+	w, err := newWatcher(c)
+	if err != nil {
+		return err
+	}
+	files, err := w.listFiles()
+	if err != nil {
+		return err
+	}
+	b, err := newBackend(w.c)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	current := map[string]File{}
+	for _, f := range files {
+		current[pathKey(f.Path)] = f
+		if err := b.Add(f.Path); err != nil {
+			w.c.Logf("watcher.Add(%q): %v", f.Path, err)
+		}
+	}
+	fn(files, nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-b.Errors():
+			w.c.Logf("watcher error: %v", err)
+		case <-b.Events():
+			files, err := w.listFiles()
+			if err != nil {
+				w.c.Logf("error listing files: %v", err)
+				continue
+			}
+			next := map[string]File{}
+			var added, removed []File
+			for _, f := range files {
+				key := pathKey(f.Path)
+				next[key] = f
+				if _, ok := current[key]; !ok {
+					added = append(added, f)
+				}
+				// Files are added again, as they may have been
+				// replaced on disk.
+				if err := b.Add(f.Path); err != nil {
+					w.c.Logf("watcher.Add(%q): %v", f.Path, err)
+				}
+			}
+			for key, f := range current {
+				if _, ok := next[key]; !ok {
+					removed = append(removed, f)
+					b.Remove(f.Path)
+				}
+			}
+			current = next
+			if len(added) > 0 || len(removed) > 0 {
+				sortFiles(removed)
+				fn(added, removed)
+			}
+		}
+	}
+}
+
+// listFiles returns the files to watch: the AssetFiles, the files of the
+// program listed by the FileSource and the AdditionalFiles, minus the ones
+// filtered out. A file matched by AssetFiles is only listed as an asset,
+// then a file matched by NoRestart or RestartOnly in that order, and a file
+// of the program matched by AdditionalFiles as a file of the program.
+func (w *watcher) listFiles() ([]File, error) {
+	var files []File
+	assets, err := globFiles(w.c.FS, w.c.AssetFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range assets {
+		files = append(files, File{Path: path, Source: SourceAsset})
+	}
+	noRestart, err := globFiles(w.c.FS, w.c.NoRestart)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range noRestart {
+		files = append(files, File{Path: path, Source: SourceLive})
+	}
+	restartOnly, err := globFiles(w.c.FS, w.c.RestartOnly)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range restartOnly {
+		files = append(files, File{Path: path, Source: SourceRestart})
+	}
+	additional, err := globFiles(w.c.FS, w.c.AdditionalFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []File
+	if p, ok := w.c.FileSource.(packageFiles); ok {
+		found, err = p.describe(w.c.Dir)
+	} else {
+		var paths []string
+		paths, err = w.c.FileSource.Files(w.c.Dir)
+		for _, path := range paths {
+			found = append(found, File{Path: path, Source: SourcePackage})
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing go files: %w", err)
+	}
+	files = append(files, found...)
+	for _, path := range additional {
+		files = append(files, File{Path: path, Source: SourceGlob})
+	}
+
+	var tracked map[string]bool
+	if w.c.GitTracked {
+		tracked, err = gitTrackedFiles(w.c.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("error listing git tracked files: %w", err)
+		}
+	}
+	var ignored map[string]bool
+	if w.ignoreRoot != "" {
+		paths := make([]string, len(files))
+		for i, f := range files {
+			paths[i] = f.Path
+		}
+		ignored, err = gitIgnored(w.ignoreRoot, paths)
+		if err != nil {
+			return nil, fmt.Errorf("error checking git ignored files: %w", err)
+		}
+	}
+	seen := map[string]bool{}
+	kept := files[:0]
+	for _, f := range files {
+		key := pathKey(f.Path)
+		if seen[key] || !w.filter.match(f.Path) || tracked != nil && !tracked[key] || ignored[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, f)
+	}
+	sortFiles(kept)
+	return kept, nil
+}
+
+func sortFiles(files []File) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+}
+
+// unimported returns the Go files matched by AdditionalFiles outside of the
+// directories of the packages of the program, if the program is listed by
+// the default FileSource, which knows its import graph.
+func unimported(source FileSource, files []File) set {
+	if _, ok := source.(packageFiles); !ok {
+		return nil
+	}
+	dirs := map[string]bool{}
+	for _, f := range files {
+		if f.Source == SourcePackage || f.Source == SourceVendor {
+			dirs[pathKey(filepath.Dir(f.Path))] = true
+		}
+	}
+	s := set{}
+	for _, f := range files {
+		if f.Source == SourceGlob && filepath.Ext(f.Path) == ".go" && !dirs[pathKey(filepath.Dir(f.Path))] {
+			s.add(f.Path)
+		}
+	}
+	return s
+}
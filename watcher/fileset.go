@@ -0,0 +1,121 @@
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileSetSettleTime is how long to wait after a file was created, removed or
+// renamed before discovering the files again, so that a burst of changes,
+// such as an editor replacing a file on save, is picked up at once.
+const fileSetSettleTime = 100 * time.Millisecond
+
+var (
+	// buildExts are the extensions of the files the go command builds.
+	buildExts = map[string]bool{
+		".go": true, ".s": true, ".S": true, ".c": true, ".h": true,
+		".cc": true, ".cpp": true, ".cxx": true, ".hh": true, ".hpp": true,
+		".m": true, ".f": true, ".F": true, ".syso": true,
+	}
+	moduleFiles = map[string]bool{"go.mod": true, "go.sum": true, "go.work": true, "go.work.sum": true}
+)
+
+// watchDirs adds the directories of files and the ones matched by the "**"
+// elements of the glob patterns to b, so that the files created in them are
+// reported, and removes the directories that are no longer needed.
+func (w *watcher) watchDirs(b Backend, files set) {
+	dirs := set{}
+	for _, file := range files {
+		dirs.add(filepath.Dir(file))
+	}
+	dirs.add(w.patternDirs()...)
+	for key, dir := range w.dirs {
+		if _, ok := dirs[key]; !ok {
+			b.Remove(dir)
+		}
+	}
+	for key, dir := range dirs {
+		if _, ok := w.dirs[key]; ok {
+			continue
+		}
+		if err := b.Add(dir); err != nil {
+			w.c.Logf("error watching directory %s: %v", dir, err)
+		}
+	}
+	w.dirs = dirs
+}
+
+// fileSetChange describes how event may change the set of watched files, or
+// returns "" if it does not. watched reports whether the file of the event
+// is watched. A created file that is already watched was replaced, which is
+// handled as a write.
+func (w *watcher) fileSetChange(event fsnotify.Event, watched bool) string {
+	switch {
+	case watched && event.Op&fsnotify.Remove == fsnotify.Remove:
+		return "removed file"
+	case watched && event.Op&fsnotify.Rename == fsnotify.Rename:
+		return "renamed file"
+	case !watched && event.Op&fsnotify.Create == fsnotify.Create && w.mayWatch(event.Name):
+		return "created file"
+	}
+	return ""
+}
+
+// patternDirs returns the directories on disk that the "**" elements of the
+// AdditionalFiles, AssetFiles, NoRestart and RestartOnly patterns match,
+// skipping the excluded directories.
+func (w *watcher) patternDirs() []string {
+	if _, ok := w.c.FS.(osFS); !ok {
+		return nil
+	}
+	sep := string(filepath.Separator)
+	var dirs []string
+	for _, patterns := range [][]string{w.c.AdditionalFiles, w.c.AssetFiles, w.c.NoRestart, w.c.RestartOnly} {
+		for _, pattern := range patterns {
+			abs, err := filepath.Abs(filepath.FromSlash(pattern))
+			i := strings.Index(abs, sep+"**")
+			if err != nil || i < 0 {
+				continue
+			}
+			roots, _ := filepath.Glob(abs[:i])
+			for _, root := range roots {
+				filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+					if err != nil || !d.IsDir() {
+						return nil
+					}
+					if d.Name() == ".git" || w.filter.inExcludedDir(filepath.Join(path, "x")) {
+						return filepath.SkipDir
+					}
+					dirs = append(dirs, path)
+					return nil
+				})
+			}
+		}
+	}
+	return dirs
+}
+
+// mayWatch reports whether a created file may be one to watch: a Go,
+// assembly or C file, a go.mod, go.sum or go.work file or a file matched by
+// AdditionalFiles, AssetFiles, NoRestart or RestartOnly, that is not
+// filtered out. Whether the file belongs to the program is left to the file
+// discovery.
+func (w *watcher) mayWatch(name string) bool {
+	if !w.filter.match(name) {
+		return false
+	}
+	if w.ignoreRoot != "" {
+		if ignored, err := gitIgnored(w.ignoreRoot, []string{name}); err == nil && ignored[pathKey(name)] {
+			return false
+		}
+	}
+	if buildExts[filepath.Ext(name)] || moduleFiles[filepath.Base(name)] {
+		return true
+	}
+	return matchGlob(w.c.AdditionalFiles, name) || matchGlob(w.c.AssetFiles, name) ||
+		matchGlob(w.c.NoRestart, name) || matchGlob(w.c.RestartOnly, name)
+}
@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// filter decides whether a discovered file should be watched based on the
+// ExcludeDirs, Include, Exclude and ExcludePatterns settings of a Config.
+type filter struct {
+	dir         string
+	excludeDirs []string
+	include     []*regexp.Regexp
+	exclude     []*regexp.Regexp
+	patterns    []string
+}
+
+func newFilter(c Config) (*filter, error) {
+	f := &filter{dir: c.Dir, excludeDirs: c.ExcludeDirs}
+	for _, pattern := range c.ExcludePatterns {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		f.patterns = append(f.patterns, pattern)
+	}
+	for _, expr := range c.Include {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", expr, err)
+		}
+		f.include = append(f.include, re)
+	}
+	for _, expr := range c.Exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", expr, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+// match reports whether path passes the filter. Regular expressions are
+// matched against the forward slash form of path on every platform.
+func (f *filter) match(path string) bool {
+	if f.inExcludedDir(path) || f.matchesPattern(path) {
+		return false
+	}
+	slashed := filepath.ToSlash(path)
+	for _, re := range f.exclude {
+		if re.MatchString(slashed) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(slashed) {
+			return true
+		}
+	}
+	return false
+}
+
+// inExcludedDir reports whether any directory element of path, relative to
+// the working directory, matches one of the excluded directory patterns.
+func (f *filter) inExcludedDir(path string) bool {
+	if len(f.excludeDirs) == 0 {
+		return false
+	}
+	path = pathKey(path)
+	if rel, err := filepath.Rel(pathKey(f.dir), path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	dir := filepath.ToSlash(filepath.Dir(path))
+	elems := strings.Split(dir, "/")
+	for _, pattern := range f.excludeDirs {
+		pattern = strings.TrimSuffix(filepath.ToSlash(pathKey(pattern)), "/")
+		if strings.Contains(pattern, "/") {
+			if dir == pattern || strings.HasPrefix(dir, pattern+"/") {
+				return true
+			}
+			continue
+		}
+		for _, elem := range elems {
+			if ok, _ := filepath.Match(pattern, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesPattern reports whether the path of a file, relative to the
+// working directory, matches one of the ExcludePatterns.
+func (f *filter) matchesPattern(file string) bool {
+	if len(f.patterns) == 0 {
+		return false
+	}
+	rel := pathKey(file)
+	if r, err := filepath.Rel(pathKey(f.dir), rel); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+	for _, pattern := range f.patterns {
+		pattern = pathKey(pattern)
+		dirOnly := strings.HasSuffix(pattern, "/")
+		pattern = strings.Trim(pattern, "/")
+		if !strings.Contains(pattern, "/") {
+			// The pattern matches an element at any depth.
+			pattern = "**/" + pattern
+		}
+		names := elems
+		if dirOnly {
+			names = elems[:len(elems)-1]
+		}
+		// A pattern matching a directory excludes the files inside it.
+		for i := len(names); i > 0; i-- {
+			if matchElems(strings.Split(pattern, "/"), names[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchElems matches path elements against the elements of a glob pattern,
+// where "**" matches any number of elements.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
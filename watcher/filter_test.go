@@ -0,0 +1,119 @@
+package watcher
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// testFilter returns the filter of c for the module at /mod, and the path
+// of the module.
+func testFilter(t *testing.T, c Config) (*filter, string) {
+	t.Helper()
+	root, err := filepath.Abs(filepath.FromSlash("/mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Dir = root
+	f, err := newFilter(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, root
+}
+
+func TestFilterExcludeDirs(t *testing.T) {
+	for _, tc := range []struct {
+		exclude string
+		file    string // relative to the module, with forward slashes
+		want    bool   // whether the file passes the filter
+	}{
+		{"vendor", "vendor/x/x.go", false},
+		{"vendor/", "vendor/x/x.go", false},
+		{"vendor", "pkg/vendor/x.go", false},
+		{"vendor", "vendored/x.go", true},
+		{"node_*", "web/node_modules/x.go", false},
+		{"gen/out", "gen/out/x.go", false},
+		{"gen/out/", "gen/out/deep/x.go", false},
+		{"gen/out", "gen/output/x.go", true},
+		{"gen/out", "pkg/gen/out/x.go", true},
+		// A file in the excluded directory itself, not below it.
+		{"vendor", "vendor.go", true},
+		// Directory names apply outside of the module, paths do not.
+		{"vendor", "../lib/vendor/x.go", false},
+		{"gen/out", "../lib/gen/out/x.go", true},
+	} {
+		f, root := testFilter(t, Config{ExcludeDirs: []string{tc.exclude}})
+		file := filepath.Join(root, filepath.FromSlash(tc.file))
+		if got := f.match(file); got != tc.want {
+			t.Errorf("ExcludeDirs %q: match(%q) = %v, want %v", tc.exclude, file, got, tc.want)
+		}
+	}
+}
+
+func TestFilterExcludePatterns(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		file    string
+		want    bool
+	}{
+		{"*.pb.go", "api/v1/service.pb.go", false},
+		{"*.pb.go", "api/v1/service.go", true},
+		{"gen/", "gen/x.go", false},
+		{"gen/", "pkg/gen/x.go", false},
+		// A trailing slash only matches directories.
+		{"gen/", "gen", true},
+		{"internal/*/mock.go", "internal/db/mock.go", false},
+		{"internal/*/mock.go", "internal/db/sub/mock.go", true},
+		{"**/testdata/**", "pkg/testdata/a/b.go", false},
+		{"docs/**/*.go", "docs/x.go", false},
+		{"docs/**/*.go", "doc/x.go", true},
+		{"*_gen.go", "../lib/x_gen.go", false},
+	} {
+		f, root := testFilter(t, Config{ExcludePatterns: []string{tc.pattern}})
+		file := filepath.Join(root, filepath.FromSlash(tc.file))
+		if got := f.match(file); got != tc.want {
+			t.Errorf("ExcludePatterns %q: match(%q) = %v, want %v", tc.pattern, file, got, tc.want)
+		}
+	}
+}
+
+func TestFilterRegexpsUseSlashes(t *testing.T) {
+	f, root := testFilter(t, Config{Include: []string{`/cmd/`}, Exclude: []string{`/cmd/tools/`}})
+	for _, tc := range []struct {
+		file string
+		want bool
+	}{
+		{"cmd/api/main.go", true},
+		{"cmd/tools/gen.go", false},
+		{"pkg/api.go", false},
+	} {
+		file := filepath.Join(root, filepath.FromSlash(tc.file))
+		if got := f.match(file); got != tc.want {
+			t.Errorf("match(%q) = %v, want %v", file, got, tc.want)
+		}
+	}
+}
+
+// TestFilterBackslash checks that backslashes in settings and paths are
+// separators on Windows only. Elsewhere, they are part of a file name, or
+// escape the next character of a glob pattern.
+func TestFilterBackslash(t *testing.T) {
+	windows := runtime.GOOS == "windows"
+	for _, tc := range []struct {
+		c    Config
+		file string
+		want bool
+	}{
+		{Config{ExcludeDirs: []string{`gen\out`}}, "gen/out/x.go", !windows},
+		{Config{ExcludePatterns: []string{`gen\*.go`}}, "gen/x.go", !windows},
+		{Config{ExcludeDirs: []string{"gen/out"}}, `gen\out\x.go`, !windows},
+		{Config{ExcludeDirs: []string{"out"}}, `gen\out\x.go`, !windows},
+	} {
+		f, root := testFilter(t, tc.c)
+		file := filepath.Join(root, filepath.FromSlash(tc.file))
+		if got := f.match(file); got != tc.want {
+			t.Errorf("%+v: match(%q) = %v, want %v", tc.c, file, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// FS reads the files that are discovered and watched. Paths are absolute
+// and use the separator of the platform, like the names of the events of a
+// Backend.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	// Glob returns the names of the files matching pattern, with the
+	// syntax of filepath.Match.
+	Glob(pattern string) ([]string, error)
+}
+
+// osFS is the default FS, backed by the os package.
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
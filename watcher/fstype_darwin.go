@@ -0,0 +1,32 @@
+package watcher
+
+import (
+	"strings"
+	"syscall"
+)
+
+// networkFileSystems are the file systems on which FSEvents and kqueue miss
+// changes made by other machines.
+var networkFileSystems = []string{"nfs", "smbfs", "afpfs", "webdav", "osxfuse", "macfuse"}
+
+// networkFS returns the name of the network file system dir is on, or an
+// empty string.
+func networkFS(dir string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return ""
+	}
+	var name strings.Builder
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name.WriteByte(byte(c))
+	}
+	for _, fs := range networkFileSystems {
+		if name.String() == fs {
+			return fs
+		}
+	}
+	return ""
+}
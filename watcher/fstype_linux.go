@@ -0,0 +1,27 @@
+package watcher
+
+import "syscall"
+
+// networkFileSystems maps the magic numbers of the file systems on which
+// inotify misses changes made by other machines or by the host of a VM to
+// their name.
+var networkFileSystems = map[uint32]string{
+	0x6969:     "NFS",
+	0x517b:     "SMB",
+	0xff534d42: "CIFS",
+	0xfe534d42: "SMB2",
+	0x65735546: "FUSE", // sshfs, virtiofs
+	0x01021997: "9p",   // WSL2 drvfs, QEMU shared folders
+	0x53464846: "drvfs",
+	0x786f4256: "vboxsf",
+}
+
+// networkFS returns the name of the network or virtualized file system dir
+// is on, or an empty string.
+func networkFS(dir string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return ""
+	}
+	return networkFileSystems[uint32(st.Type)]
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package watcher
+
+// networkFS reports no network file systems on platforms where they are not
+// detected.
+func networkFS(dir string) string { return "" }
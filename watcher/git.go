@@ -0,0 +1,192 @@
+package watcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// git runs a git command in dir and returns its output. The error includes
+// whatever git printed to stderr.
+func git(dir string, args ...string) ([]byte, error) {
+	return gitInput(dir, nil, args...)
+}
+
+// gitInput is like git, but feeds input to the standard input of the
+// command.
+func gitInput(dir string, input []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git %s: %s", args[0], bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// gitRoot returns the top level directory of the git repository that
+// contains dir.
+func gitRoot(dir string) (string, error) {
+	out, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(strings.TrimSpace(string(out))), nil
+}
+
+// gitTrackedFiles returns the pathKeys of the absolute paths of all files
+// tracked by the git repository that contains dir.
+func gitTrackedFiles(dir string) (map[string]bool, error) {
+	root, err := gitRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(root, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, name := range bytes.Split(out, []byte{0}) {
+		if len(name) > 0 {
+			files[pathKey(filepath.Join(root, filepath.FromSlash(string(name))))] = true
+		}
+	}
+	return files, nil
+}
+
+// gitIgnored returns the pathKeys of the paths that the git repository at
+// root ignores. Paths outside of the repository are never ignored.
+func gitIgnored(root string, paths []string) (map[string]bool, error) {
+	var input []byte
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		input = append(input, filepath.ToSlash(rel)...)
+		input = append(input, 0)
+	}
+	ignored := map[string]bool{}
+	if len(input) == 0 {
+		return ignored, nil
+	}
+	out, err := gitInput(root, input, "check-ignore", "--stdin", "-z")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// check-ignore exits with 1 when none of the paths are ignored.
+		return ignored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range bytes.Split(out, []byte{0}) {
+		if len(name) > 0 {
+			ignored[pathKey(filepath.Join(root, filepath.FromSlash(string(name))))] = true
+		}
+	}
+	return ignored, nil
+}
+
+const (
+	// gitSettleTime is how long to wait after HEAD changes for the rest of
+	// a branch switch to be written to the working tree.
+	gitSettleTime = 500 * time.Millisecond
+	// gitOperationPollInterval is how often to check whether a paused git
+	// operation has completed.
+	gitOperationPollInterval = time.Second
+)
+
+// gitWatch detects branch switches in the git repository that contains the
+// watched directory by watching its HEAD and packed-refs files.
+type gitWatch struct {
+	root   string // the repository's top level directory
+	gitDir string
+	files  map[string]string
+	head   string
+}
+
+// newGitWatch adds the HEAD and packed-refs files of the repository that
+// contains dir to b. It returns nil if dir is not in a git repository.
+func newGitWatch(dir string, b Backend) *gitWatch {
+	out, err := git(dir, "rev-parse", "--absolute-git-dir", "--show-toplevel")
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		return nil
+	}
+	gitDir := filepath.FromSlash(lines[0])
+	g := &gitWatch{root: filepath.FromSlash(lines[1]), gitDir: gitDir, files: map[string]string{}}
+	for _, name := range []string{"HEAD", "packed-refs"} {
+		path := filepath.Join(gitDir, name)
+		g.files[pathKey(path)] = path
+		b.Add(path) // packed-refs may not exist yet
+	}
+	g.head, _ = g.state()
+	return g
+}
+
+func (g *gitWatch) owns(name string) bool {
+	_, ok := g.files[pathKey(name)]
+	return ok
+}
+
+// changed re-adds name to b, since git replaces these files instead of
+// writing to them, and reports whether HEAD now points somewhere else.
+func (g *gitWatch) changed(b Backend, name string) bool {
+	b.Add(g.files[pathKey(name)])
+	head, err := g.state()
+	if err != nil || head == g.head {
+		return false
+	}
+	g.head = head
+	return true
+}
+
+// state returns the branch and commit HEAD points to.
+func (g *gitWatch) state() (string, error) {
+	out, err := git(g.root, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	branch := strings.TrimSpace(string(out))
+	out, err = git(g.root, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return branch + " (" + strings.TrimSpace(string(out)) + ")", nil
+}
+
+// gitOperations maps the files git keeps in its directory while an operation
+// is in progress to the name of the operation.
+var gitOperations = []struct{ file, name string }{
+	{"rebase-merge", "rebase"},
+	{"rebase-apply", "rebase"},
+	{"MERGE_HEAD", "merge"},
+	{"CHERRY_PICK_HEAD", "cherry-pick"},
+	{"REVERT_HEAD", "revert"},
+}
+
+// operation returns the name of the git operation in progress, or an empty
+// string if there is none.
+func (g *gitWatch) operation() string {
+	for _, op := range gitOperations {
+		if _, err := os.Stat(filepath.Join(g.gitDir, op.file)); err == nil {
+			return op.name
+		}
+	}
+	return ""
+}
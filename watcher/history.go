@@ -0,0 +1,223 @@
+package watcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cycle describes one build of the program and the run that followed it.
+type Cycle struct {
+	Time time.Time `json:"time"`
+	// Trigger is the file whose change caused the cycle. It is empty for
+	// the first build and for cycles that were not caused by a file.
+	Trigger       string        `json:"trigger,omitempty"`
+	BuildDuration time.Duration `json:"buildDuration"`
+	// Result is one of the Result constants.
+	Result string        `json:"result"`
+	Error  string        `json:"error,omitempty"`
+	Uptime time.Duration `json:"uptime"`
+}
+
+// String formats the cycle as a single line.
+func (c Cycle) String() string {
+	s := fmt.Sprintf("%s  %-12s  build %-7v  up %-8v",
+		c.Time.Format("2006-01-02 15:04:05"), c.Result,
+		c.BuildDuration.Round(time.Millisecond), c.Uptime.Round(time.Second))
+	return strings.TrimSpace(s + "  " + c.Trigger)
+}
+
+// The results of a Cycle.
+const (
+	ResultBuildFailed = "build failed"
+	ResultBuilt       = "built"
+	ResultRestarted   = "restarted"
+	ResultExited      = "exited"
+	ResultCrashed     = "crashed"
+	ResultStopped     = "stopped"
+)
+
+const defaultHistorySize = 100
+
+// history keeps the last cycles in memory and appends every finished cycle
+// to a file if one is configured.
+type history struct {
+	clock Clock
+	logf  func(string, ...any)
+	size  int
+	path  string
+
+	mu      sync.Mutex
+	cycles  []Cycle
+	current *Cycle
+	started time.Time // when the program of the current cycle started
+	created time.Time
+	totals  totals
+}
+
+// totals are counted over every cycle, including the ones no longer kept.
+type totals struct {
+	cycles, builds, failures, crashes, starts int
+	build, ready                              time.Duration
+}
+
+// Summary describes a whole run of gowatch.
+type Summary struct {
+	Cycles        int `json:"cycles"`
+	BuildFailures int `json:"buildFailures"`
+	Crashes       int `json:"crashes"`
+	// AverageBuild is the average duration of the builds, and AverageReady
+	// the average time from the start of a cycle to the start of the
+	// program.
+	AverageBuild time.Duration `json:"averageBuild"`
+	AverageReady time.Duration `json:"averageReady"`
+	WallClock    time.Duration `json:"wallClock"`
+}
+
+func newHistory(c Config) *history {
+	size := c.HistorySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	return &history{clock: c.Clock, logf: c.Logf, size: size, path: c.History, created: c.Clock.Now()}
+}
+
+// begin starts a new cycle caused by trigger.
+func (h *history) begin(trigger string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current = &Cycle{Time: h.clock.Now(), Trigger: trigger}
+	h.started = time.Time{}
+}
+
+// built records the outcome of the build of the current cycle, finishing it
+// if the build failed.
+func (h *history) built(err error) {
+	h.mu.Lock()
+	if h.current != nil {
+		h.current.BuildDuration = h.clock.Now().Sub(h.current.Time)
+		h.totals.builds++
+		h.totals.build += h.current.BuildDuration
+		if err != nil {
+			h.totals.failures++
+		}
+	}
+	h.mu.Unlock()
+	if err != nil {
+		h.finish(ResultBuildFailed, err)
+	}
+}
+
+// running records that the program of the current cycle started.
+func (h *history) running() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = h.clock.Now()
+	if h.current != nil {
+		h.totals.starts++
+		h.totals.ready += h.started.Sub(h.current.Time)
+	}
+}
+
+// finish ends the current cycle with result, if there is one.
+func (h *history) finish(result string, err error) {
+	h.mu.Lock()
+	if h.current == nil {
+		h.mu.Unlock()
+		return
+	}
+	c := *h.current
+	h.current = nil
+	c.Result = result
+	if err != nil {
+		c.Error = err.Error()
+	}
+	if !h.started.IsZero() {
+		c.Uptime = h.clock.Now().Sub(h.started)
+	}
+	h.totals.cycles++
+	if result == ResultCrashed {
+		h.totals.crashes++
+	}
+	h.cycles = append(h.cycles, c)
+	if len(h.cycles) > h.size {
+		h.cycles = h.cycles[len(h.cycles)-h.size:]
+	}
+	h.mu.Unlock()
+	if h.path != "" {
+		if err := appendCycle(h.path, c); err != nil {
+			h.logf("error writing history: %v", err)
+		}
+	}
+}
+
+func appendCycle(path string, c Cycle) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// snapshot returns a copy of the cycles kept in memory, oldest first.
+func (h *history) snapshot() []Cycle {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Cycle(nil), h.cycles...)
+}
+
+// summary returns the summary of the run so far.
+func (h *history) summary() Summary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.totals
+	s := Summary{
+		Cycles:        t.cycles,
+		BuildFailures: t.failures,
+		Crashes:       t.crashes,
+		WallClock:     h.clock.Now().Sub(h.created),
+	}
+	if t.builds > 0 {
+		s.AverageBuild = t.build / time.Duration(t.builds)
+	}
+	if t.starts > 0 {
+		s.AverageReady = t.ready / time.Duration(t.starts)
+	}
+	return s
+}
+
+// writeSummary writes the summary of the run to path as JSON.
+func (h *history) writeSummary(path string) error {
+	b, err := json.MarshalIndent(h.summary(), "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// ReadHistory reads the cycles written to a history file.
+func ReadHistory(path string) ([]Cycle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cycles []Cycle
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var c Cycle
+		if err := json.Unmarshal(s.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		cycles = append(cycles, c)
+	}
+	return cycles, s.Err()
+}
@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// buildHooks runs the OnFailure hook when a build fails after a successful
+// one, or when the first build fails, and the OnSuccess hook when a build
+// succeeds after a failed one.
+func (w *watcher) buildHooks(ctx context.Context, r BuildResult) {
+	failed := !r.Success
+	if failed == w.failing {
+		return
+	}
+	w.failing = failed
+	hook, status := w.c.OnSuccess, "success"
+	if failed {
+		hook, status = w.c.OnFailure, "failure"
+	}
+	if hook == "" {
+		return
+	}
+	env := append(w.runEnv(),
+		"GOWATCH_STATUS="+status,
+		"GOWATCH_TRIGGER="+w.trigger,
+		"GOWATCH_BUILD_DURATION="+r.Duration.Round(time.Millisecond).String(),
+		fmt.Sprintf("GOWATCH_ERROR_COUNT=%d", len(r.Diagnostics)),
+	)
+	if failed {
+		env = append(env, "GOWATCH_ERROR="+firstLine(r.Output))
+	}
+	w.hooks.Add(1)
+	go w.runHook(ctx, status, hook, env)
+}
+
+// assetChanged runs the OnAssetChange command for the changed asset file.
+func (w *watcher) assetChanged(ctx context.Context, file string) {
+	if w.c.OnAssetChange == "" {
+		return
+	}
+	env := append(w.runEnv(), "GOWATCH_FILE="+file)
+	w.hooks.Add(1)
+	go w.runHook(ctx, "asset change", w.c.OnAssetChange, env)
+}
+
+func (w *watcher) runHook(ctx context.Context, status, hook string, env []string) {
+	defer w.hooks.Done()
+	fields := strings.Fields(hook)
+	err := w.c.Runner.Run(ctx, Cmd{
+		Name:   fields[0],
+		Args:   fields[1:],
+		Dir:    w.c.Dir,
+		Env:    env,
+		Stdout: w.c.Stdout,
+		Stderr: w.c.Stderr,
+	})
+	if err != nil && ctx.Err() == nil {
+		w.c.Logf("error running the %s hook: %v", status, err)
+	}
+}
+
+// firstLine returns the first line of s that is not a "# package" header.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line
+		}
+	}
+	return ""
+}
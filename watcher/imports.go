@@ -0,0 +1,56 @@
+package watcher
+
+import (
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// importsChanged reports whether the imports of the Go file at path changed
+// since the last call, in which case the files of the packages it imports
+// now must be discovered.
+func (w *watcher) importsChanged(path string) bool {
+	if !strings.HasSuffix(path, ".go") {
+		return false
+	}
+	imports, ok := fileImports(w.c.FS, path)
+	if !ok {
+		return false
+	}
+	old, seen := w.imports[pathKey(path)]
+	w.imports[pathKey(path)] = imports
+	return seen && old != imports
+}
+
+// recordImports records the imports of every watched Go file.
+func (w *watcher) recordImports() {
+	w.imports = map[string]string{}
+	for key, path := range w.files {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		if imports, ok := fileImports(w.c.FS, path); ok {
+			w.imports[key] = imports
+		}
+	}
+}
+
+// fileImports returns the sorted import paths of the Go file at path in
+// fsys, one per line.
+func fileImports(fsys FS, path string) (string, bool) {
+	src, err := fsys.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), path, src, parser.ImportsOnly)
+	if err != nil {
+		return "", false
+	}
+	paths := make([]string, 0, len(f.Imports))
+	for _, spec := range f.Imports {
+		paths = append(paths, spec.Path.Value)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, "\n"), true
+}
@@ -0,0 +1,28 @@
+package watcher
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// interruptWindow is how soon a second interrupt must follow the first one
+// to quit with RestartOnInterrupt.
+const interruptWindow = time.Second
+
+// quitInput returns a channel that is closed when a line reading "q" is
+// read from r.
+func quitInput(r io.Reader) <-chan struct{} {
+	quit := make(chan struct{})
+	go func() {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			if strings.TrimSpace(s.Text()) == "q" {
+				close(quit)
+				return
+			}
+		}
+	}()
+	return quit
+}
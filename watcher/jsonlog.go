@@ -0,0 +1,127 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The formats of the log of gowatch.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logEvent is one line of the JSON log.
+type logEvent struct {
+	Time time.Time `json:"time"`
+	// Name is the name of the program, see Config.Name.
+	Name  string `json:"name,omitempty"`
+	Event string `json:"event"`
+	// Msg is the text of the message of a log event, and the message of
+	// a panic event.
+	Msg  string `json:"msg,omitempty"`
+	File string `json:"file,omitempty"`
+	// Line and Function locate the panic of a panic event in File.
+	Line       int    `json:"line,omitempty"`
+	Function   string `json:"function,omitempty"`
+	DurationMS int64  `json:"durationMs,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Diagnostics, BinaryPath and GitSHA are the ones of the BuildResult
+	// of a build event.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	BinaryPath  string       `json:"binaryPath,omitempty"`
+	GitSHA      string       `json:"gitSHA,omitempty"`
+}
+
+// The events of the JSON log.
+const (
+	eventLog            = "log"
+	eventFileChanged    = "file_changed"
+	eventBuildStarted   = "build_started"
+	eventBuildSucceeded = "build_succeeded"
+	eventBuildFailed    = "build_failed"
+	eventProcessStarted = "process_started"
+	eventProcessExited  = "process_exited"
+	eventPanic          = "panic"
+)
+
+// jsonLog writes the messages and events of a watcher as JSON lines.
+type jsonLog struct {
+	clock Clock
+	name  string
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONLog(w io.Writer, clock Clock, name string) *jsonLog {
+	return &jsonLog{clock: clock, name: name, enc: json.NewEncoder(w)}
+}
+
+func (l *jsonLog) emit(e logEvent) {
+	e.Time, e.Name = l.clock.Now(), l.name
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(e)
+}
+
+// logf is a Config.Logf writing log events, without colors.
+func (l *jsonLog) logf(format string, a ...any) {
+	l.emit(logEvent{Event: eventLog, Msg: stripANSI(fmt.Sprintf(format, a...))})
+}
+
+// hook makes c log to l, see hookEvents.
+func (l *jsonLog) hook(c *Config) {
+	c.Logf = l.logf
+	l.hookEvents(c)
+}
+
+// hookEvents makes c report its file changes, builds, processes and
+// panics to l, in addition to its own callbacks.
+func (l *jsonLog) hookEvents(c *Config) {
+	onFileChange, onBuildStart, onBuild, onStart, onExit, onPanic := c.OnFileChange, c.OnBuildStart, c.OnBuild, c.OnProcessStart, c.OnProcessExit, c.OnPanic
+	c.OnFileChange = func(file string) {
+		l.emit(logEvent{Event: eventFileChanged, File: file})
+		onFileChange(file)
+	}
+	c.OnBuildStart = func() {
+		l.emit(logEvent{Event: eventBuildStarted})
+		onBuildStart()
+	}
+	c.OnBuild = func(r BuildResult) {
+		e := logEvent{
+			Event:       eventBuildSucceeded,
+			DurationMS:  r.Duration.Milliseconds(),
+			Diagnostics: r.Diagnostics,
+			BinaryPath:  r.BinaryPath,
+			GitSHA:      r.GitSHA,
+		}
+		if !r.Success {
+			e.Event, e.Error = eventBuildFailed, firstLine(r.Output)
+			if e.Error == "" && r.Err != nil {
+				e.Error = r.Err.Error()
+			}
+		}
+		l.emit(e)
+		onBuild(r)
+	}
+	c.OnProcessStart = func() {
+		l.emit(logEvent{Event: eventProcessStarted})
+		onStart()
+	}
+	c.OnProcessExit = func(err error) {
+		e := logEvent{Event: eventProcessExited}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		l.emit(e)
+		onExit(err)
+	}
+	c.OnPanic = func(p Panic) {
+		l.emit(logEvent{Event: eventPanic, Msg: p.Message, File: p.File, Line: p.Line, Function: p.Function})
+		onPanic(p)
+	}
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The actions of the dashboard that can be bound to keys with Config.Keys.
+const (
+	KeyRestart = "restart"
+	KeyPause   = "pause"
+	KeyFilter  = "filter"
+	KeySearch  = "search"
+	KeyClear   = "clear"
+	KeyHistory = "history"
+	KeyWrite   = "write"
+	KeyQuit    = "quit"
+	KeyOlder   = "older" // previous search match
+	KeyNewer   = "newer" // next search match
+)
+
+// defaultKeys are the keys of the dashboard actions.
+var defaultKeys = map[string]string{
+	KeyRestart: "r",
+	KeyPause:   "p",
+	KeyFilter:  "f",
+	KeySearch:  "/",
+	KeyClear:   "c",
+	KeyHistory: "h",
+	KeyWrite:   "w",
+	KeyQuit:    "q",
+	KeyOlder:   "n",
+	KeyNewer:   "N",
+}
+
+// keyBindings returns the key of every action, from defaultKeys overridden
+// by keys, and the action of every key.
+func keyBindings(keys map[string]string) (map[string]string, map[byte]string, error) {
+	bound := map[string]string{}
+	for action, key := range defaultKeys {
+		bound[action] = key
+	}
+	actions := make([]string, 0, len(keys))
+	for action := range keys {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		key := keys[action]
+		if _, ok := defaultKeys[action]; !ok {
+			return nil, nil, fmt.Errorf("unknown key action %q", action)
+		}
+		if len(key) != 1 || key[0] < 33 || key[0] > 126 {
+			return nil, nil, fmt.Errorf("key of %q must be a single printable ASCII character, got %q", action, key)
+		}
+		bound[action] = key
+	}
+	byKey := map[byte]string{}
+	for action, key := range bound {
+		if other, ok := byKey[key[0]]; ok {
+			a, b := other, action
+			if b < a {
+				a, b = b, a
+			}
+			return nil, nil, fmt.Errorf("key %q is bound to both %q and %q", key, a, b)
+		}
+		byKey[key[0]] = action
+	}
+	return bound, byKey, nil
+}
+
+// keyHelp describes the given actions with their keys, for the footer of the
+// dashboard.
+func keyHelp(bound map[string]string, actions ...string) string {
+	var b strings.Builder
+	for _, action := range actions {
+		label := action
+		switch action {
+		case KeyWrite:
+			label = "write log"
+		case KeyOlder, KeyNewer:
+			label += " match"
+		}
+		fmt.Fprintf(&b, " %s %s ", bound[action], label)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// lineWriter is an io.Writer that calls fn with every complete line written
+// to it, including its trailing newline. Partial lines are buffered until
+// they are completed or Flush is called.
+type lineWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	fn  func(line string)
+}
+
+func newLineWriter(fn func(line string)) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(string(w.buf[:i+1]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes any buffered partial line to fn.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// prefixLines returns a writer that writes every line to w with prefix, in
+// a single Write, so that the lines of programs sharing w do not mix.
+func prefixLines(w io.Writer, prefix string) *lineWriter {
+	return newLineWriter(func(line string) {
+		io.WriteString(w, prefix+line)
+	})
+}
+
+// tagColors are the colors of the tags of the programs, given out in order
+// as docker-compose does.
+var tagColors = []color.Attribute{
+	color.FgCyan, color.FgYellow, color.FgGreen, color.FgMagenta, color.FgBlue,
+	color.FgHiCyan, color.FgHiYellow, color.FgHiGreen, color.FgHiMagenta, color.FgHiBlue,
+}
+
+// tag returns the colored [name] tag of the i-th program, padded to the
+// width of the longest name.
+func tag(name string, i, width int) string {
+	return color.New(tagColors[i%len(tagColors)]).Sprintf("%-*s", width+2, "["+name+"]") + " "
+}
+
+// tagOutput prefixes every line of the output of the program and of the log
+// of c with tag. The JSON log names the program in a field instead. The
+// returned function writes out the last partial lines.
+func (c *Config) tagOutput(tag string) (flush func()) {
+	stdout, stderr, logf := c.Stdout, c.Stderr, c.Logf
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if logf == nil {
+		logf = log.Printf
+	}
+	outw, errw := prefixLines(stdout, tag), prefixLines(stderr, tag)
+	c.Stdout, c.Stderr = outw, errw
+	if c.LogFormat != LogFormatJSON {
+		// The name in the tag may contain a %.
+		c.Logf = func(format string, a ...any) { logf("%s"+format, append([]any{tag}, a...)...) }
+	}
+	return func() {
+		outw.Flush()
+		errw.Flush()
+	}
+}
@@ -0,0 +1,253 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveReloadPath is the path of the server-sent events endpoint of the
+// proxy, which sends a message to the browser when it should reload.
+const liveReloadPath = "/__gowatch/livereload"
+
+// liveReloadScript is injected into the HTML pages served by the proxy.
+const liveReloadScript = `<script>new EventSource("` + liveReloadPath + `").onmessage = function() { location.reload() }</script>`
+
+// How long to wait for the program to accept connections before reloading
+// the browsers, and how often to try.
+const (
+	targetReadyTimeout = 10 * time.Second
+	targetReadyPoll    = 50 * time.Millisecond
+)
+
+// liveReload is a reverse proxy in front of the program that injects
+// liveReloadScript into HTML pages, and tells the browsers to reload once
+// the program restarted.
+type liveReload struct {
+	target string // host:port of the program
+	server *http.Server
+	clock  Clock
+	logf   func(string, ...any)
+
+	mu       sync.Mutex
+	clients  map[chan struct{}]bool
+	cancel   context.CancelFunc // of the pending reload
+	building bool
+	buildErr string // output of the last build if it failed
+	events   *eventStream
+}
+
+func newLiveReload(listen, target string, origins []string, clock Clock, logf func(string, ...any)) (*liveReload, error) {
+	if strings.HasPrefix(target, ":") {
+		target = "localhost" + target
+	}
+	l := &liveReload{target: target, clock: clock, logf: logf, clients: map[chan struct{}]bool{}, events: newEventStream(origins)}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(&url.URL{Scheme: "http", Host: target})
+			r.Out.Host = r.In.Host
+			// Compressed pages could not be modified.
+			r.Out.Header.Del("Accept-Encoding")
+		},
+		ModifyResponse: injectScript,
+		ErrorHandler:   l.servePlaceholder,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(liveReloadPath, l.serveEvents)
+	mux.HandleFunc(eventsPath, l.events.serve)
+	mux.Handle("/", proxy)
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+	l.server = &http.Server{Handler: mux}
+	go l.server.Serve(ln)
+	logf("live reload proxy listening on %s, forwarding to %s", ln.Addr(), target)
+	return l, nil
+}
+
+// placeholderPage is served instead of the pages of the program while it is
+// down, and reloads once it is back.
+const placeholderPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>%s</title>
+<style>body{font-family:sans-serif;margin:2em}pre{background:#fee;padding:1em;overflow:auto}</style>
+</head><body><h1>%s</h1>%s%s</body></html>`
+
+// servePlaceholder answers the requests the program cannot, because it is
+// being rebuilt, failed to build or is not listening yet, with a page that
+// shows why and reloads once the program is back.
+func (l *liveReload) servePlaceholder(w http.ResponseWriter, r *http.Request, err error) {
+	l.mu.Lock()
+	building, buildErr := l.building, l.buildErr
+	l.mu.Unlock()
+	title, body := "Waiting for the program…", fmt.Sprintf("<p>%s: %s</p>", html.EscapeString(l.target), html.EscapeString(err.Error()))
+	switch {
+	case building:
+		title, body = "Rebuilding…", ""
+	case buildErr != "":
+		title, body = "Build failed", "<pre>"+html.EscapeString(buildErr)+"</pre>"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintf(w, placeholderPage, title, title, body, liveReloadScript)
+}
+
+// buildStarted shows the rebuilding page while the program is down.
+func (l *liveReload) buildStarted() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.building = true
+}
+
+// buildFinished shows the errors of a failed build while the program is
+// down, reloading the browsers that show the rebuilding page.
+func (l *liveReload) buildFinished(output string, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.building, l.buildErr = false, ""
+	if err != nil {
+		l.buildErr = stripANSI(output)
+		if l.buildErr == "" {
+			l.buildErr = err.Error()
+		}
+	}
+	l.mu.Unlock()
+	if err != nil && !l.targetUp() {
+		l.notify()
+	}
+}
+
+// injectScript adds liveReloadScript to the end of the body of HTML pages.
+func injectScript(resp *http.Response) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if i := bytes.LastIndex(body, []byte("</body>")); i >= 0 {
+		body = append(body[:i:i], append([]byte(liveReloadScript), body[i:]...)...)
+	} else {
+		body = append(body, liveReloadScript...)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func (l *liveReload) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ch := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.clients[ch] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.clients, ch)
+		l.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			io.WriteString(w, "data: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// reload tells the browsers to reload once the program accepts
+// connections, replacing the pending reload if any.
+func (l *liveReload) reload() {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.mu.Lock()
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.cancel = cancel
+	l.mu.Unlock()
+	go func() {
+		if l.waitTarget(ctx) {
+			l.notify()
+		}
+	}()
+}
+
+// notify tells the browsers to reload.
+func (l *liveReload) notify() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// targetUp reports whether the program accepts connections.
+func (l *liveReload) targetUp() bool {
+	conn, err := net.DialTimeout("tcp", l.target, targetReadyPoll)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// waitTarget waits until the program accepts connections, reporting false
+// if ctx is done first.
+func (l *liveReload) waitTarget(ctx context.Context) bool {
+	timeout := l.clock.After(targetReadyTimeout)
+	for {
+		if l.targetUp() {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timeout:
+			l.logf("%s did not accept connections within %v, reloading anyway", l.target, targetReadyTimeout)
+			return true
+		case <-l.clock.After(targetReadyPoll):
+		}
+	}
+}
+
+func (l *liveReload) close() {
+	l.mu.Lock()
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.mu.Unlock()
+	l.server.Close()
+}
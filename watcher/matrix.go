@@ -0,0 +1,127 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/fatih/color"
+)
+
+const defaultMatrixOutput = "bin/{{.GOOS}}_{{.GOARCH}}/{{.Name}}{{.Ext}}"
+
+// matrixTarget is the data the MatrixOutput template is executed with.
+type matrixTarget struct {
+	GOOS, GOARCH string
+	Name         string // base name of the working directory
+	Ext          string // ".exe" for windows
+
+	check bool // only check that the target compiles
+}
+
+// parseMatrix parses the os/arch pairs of c.Matrix and c.CheckBuilds, and
+// the output template.
+func parseMatrix(c Config) ([]matrixTarget, *template.Template, error) {
+	var targets []matrixTarget
+	for _, pairs := range [][]string{c.Matrix, c.CheckBuilds} {
+		for _, pair := range pairs {
+			goos, goarch, ok := strings.Cut(pair, "/")
+			if !ok || goos == "" || goarch == "" {
+				return nil, nil, fmt.Errorf("invalid build target %q, want os/arch", pair)
+			}
+			t := matrixTarget{GOOS: goos, GOARCH: goarch, Name: filepath.Base(filepath.Join(c.Dir, c.Package))}
+			if goos == "windows" {
+				t.Ext = ".exe"
+			}
+			t.check = len(c.Matrix) <= len(targets)
+			targets = append(targets, t)
+		}
+	}
+	output := c.MatrixOutput
+	if output == "" {
+		output = defaultMatrixOutput
+	}
+	tmpl, err := template.New("matrix").Option("missingkey=error").Parse(output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid matrix output: %w", err)
+	}
+	return targets, tmpl, nil
+}
+
+// buildMatrix cross-compiles the matrix and check build targets in the
+// background, canceling the builds of the previous call.
+func (w *watcher) buildMatrix(ctx context.Context) {
+	if len(w.matrix) == 0 {
+		return
+	}
+	if w.cancelMatrix != nil {
+		w.cancelMatrix()
+	}
+	ctx, w.cancelMatrix = context.WithCancel(ctx)
+	go func() {
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			failed int
+		)
+		for _, t := range w.matrix {
+			wg.Add(1)
+			go func(t matrixTarget) {
+				defer wg.Done()
+				if err := w.crossCompile(ctx, t); err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}(t)
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+		if failed > 0 {
+			w.c.Logf(color.RedString("%d of %d platforms failed to build", failed, len(w.matrix)))
+			return
+		}
+		w.c.Logf(color.GreenString("%d platforms built", len(w.matrix)))
+	}()
+}
+
+func (w *watcher) crossCompile(ctx context.Context, t matrixTarget) error {
+	out := os.DevNull
+	if !t.check {
+		var path bytes.Buffer
+		if err := w.matrixOutput.Execute(&path, t); err != nil {
+			w.c.Logf("%s/%s: %v", t.GOOS, t.GOARCH, err)
+			return err
+		}
+		out = path.String()
+		if !filepath.IsAbs(out) {
+			out = filepath.Join(w.c.Dir, out)
+		}
+	}
+	var output bytes.Buffer
+	env := append(os.Environ(), w.c.BuildEnv...)
+	args := append([]string{"build", "-o=" + out}, w.c.BuildFlags...)
+	if w.c.Package != "" {
+		args = append(args, w.c.Package)
+	}
+	err := w.c.Runner.Run(ctx, Cmd{
+		Name:   w.goCommand(),
+		Args:   args,
+		Dir:    w.c.Dir,
+		Env:    append(env, "GOOS="+t.GOOS, "GOARCH="+t.GOARCH),
+		Stdout: &output,
+		Stderr: &output,
+	})
+	if err != nil && ctx.Err() == nil {
+		w.c.Logf(color.RedString("%s/%s build failed:", t.GOOS, t.GOARCH))
+		fmt.Fprint(w.c.Stderr, output.String())
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// MemFS is an in-memory file system. It is an FS and a FileSource, listing
+// the Go files under a directory, and its Backend reports the changes made
+// with WriteFile and RemoveFile. Along with a Runner and a Clock, it runs
+// the watcher without touching the disk, such as in tests or to watch
+// virtual or remote file systems.
+type MemFS struct {
+	mu      sync.Mutex
+	files   map[string]memFile // by pathKey
+	watched map[string]bool
+	events  chan fsnotify.Event
+	errors  chan error
+}
+
+type memFile struct {
+	name string
+	data []byte
+}
+
+// memEventBuffer is how many events a MemFS holds before WriteFile and
+// RemoveFile block until they are received.
+const memEventBuffer = 64
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files:   map[string]memFile{},
+		watched: map[string]bool{},
+		events:  make(chan fsnotify.Event, memEventBuffer),
+		errors:  make(chan error),
+	}
+}
+
+// WriteFile creates or replaces the file name.
+func (m *MemFS) WriteFile(name string, data []byte) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	_, exists := m.files[pathKey(name)]
+	m.files[pathKey(name)] = memFile{name: name, data: append([]byte(nil), data...)}
+	watched := m.watched[pathKey(name)] || m.watched[pathKey(filepath.Dir(name))]
+	m.mu.Unlock()
+	op := fsnotify.Write
+	if !exists {
+		op = fsnotify.Create
+	}
+	if watched {
+		m.events <- fsnotify.Event{Name: name, Op: op}
+	}
+}
+
+// RemoveFile removes the file name, if it exists.
+func (m *MemFS) RemoveFile(name string) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	_, exists := m.files[pathKey(name)]
+	delete(m.files, pathKey(name))
+	watched := m.watched[pathKey(name)] || m.watched[pathKey(filepath.Dir(name))]
+	m.mu.Unlock()
+	if exists && watched {
+		m.events <- fsnotify.Event{Name: name, Op: fsnotify.Remove}
+	}
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[pathKey(filepath.Clean(name))]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (m *MemFS) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, name := range m.names() {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			matches = append(matches, name)
+		}
+	}
+	return matches, nil
+}
+
+// Files lists the Go files under dir.
+func (m *MemFS) Files(dir string) ([]string, error) {
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	var files []string
+	for _, name := range m.names() {
+		if strings.HasPrefix(pathKey(name), pathKey(prefix)) && strings.HasSuffix(name, ".go") {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// names returns the sorted names of the files.
+func (m *MemFS) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for _, f := range m.files {
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Backend returns the Backend reporting the changes to the files of m. It
+// is meant to be set as Config.Changes.
+func (m *MemFS) Backend() Backend { return memBackend{m} }
+
+type memBackend struct{ m *MemFS }
+
+func (b memBackend) Add(name string) error {
+	b.m.mu.Lock()
+	defer b.m.mu.Unlock()
+	b.m.watched[pathKey(filepath.Clean(name))] = true
+	return nil
+}
+
+func (b memBackend) Remove(name string) error {
+	b.m.mu.Lock()
+	defer b.m.mu.Unlock()
+	delete(b.m.watched, pathKey(filepath.Clean(name)))
+	return nil
+}
+
+func (b memBackend) Events() <-chan fsnotify.Event { return b.m.events }
+func (b memBackend) Errors() <-chan error          { return b.m.errors }
+
+// Close stops nothing, as the MemFS outlives the runs of the watcher.
+func (b memBackend) Close() error { return nil }
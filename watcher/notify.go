@@ -0,0 +1,148 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rjeczalik/notify"
+)
+
+// notifyBackend is a Backend built on rjeczalik/notify. It watches the
+// directories of the added files recursively, which uses the native
+// recursive APIs (FSEvents, ReadDirectoryChangesW) where the platform has
+// them and needs a single watch per root instead of one per file.
+type notifyBackend struct {
+	events chan fsnotify.Event
+	errs   chan error
+	done   chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	files map[string]string // pathKey to the added path
+	roots map[string]chan notify.EventInfo
+}
+
+func newNotifyBackend() *notifyBackend {
+	return &notifyBackend{
+		events: make(chan fsnotify.Event),
+		errs:   make(chan error),
+		done:   make(chan struct{}),
+		files:  map[string]string{},
+		roots:  map[string]chan notify.EventInfo{},
+	}
+}
+
+func (b *notifyBackend) Add(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	dir := abs
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		dir = filepath.Dir(abs)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files[pathKey(abs)] = abs
+	for root := range b.roots {
+		if isWithin(dir, root) {
+			return nil
+		}
+	}
+	ch := make(chan notify.EventInfo, 64)
+	if err := notify.Watch(filepath.Join(dir, "..."), ch, notify.All); err != nil {
+		return err
+	}
+	// The new root supersedes the roots nested inside it.
+	for root, rootCh := range b.roots {
+		if isWithin(root, dir) {
+			notify.Stop(rootCh)
+			delete(b.roots, root)
+		}
+	}
+	b.roots[dir] = ch
+	go b.forward(ch)
+	return nil
+}
+
+func (b *notifyBackend) Remove(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	delete(b.files, pathKey(abs))
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *notifyBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *notifyBackend) Errors() <-chan error          { return b.errs }
+
+func (b *notifyBackend) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+		b.mu.Lock()
+		for _, ch := range b.roots {
+			notify.Stop(ch)
+		}
+		b.mu.Unlock()
+	})
+	return nil
+}
+
+func (b *notifyBackend) forward(ch chan notify.EventInfo) {
+	for {
+		var ei notify.EventInfo
+		select {
+		case <-b.done:
+			return
+		case ei = <-ch:
+		}
+		b.mu.Lock()
+		name, ok := b.files[pathKey(ei.Path())]
+		if _, inDir := b.files[pathKey(filepath.Dir(ei.Path()))]; !ok && inDir {
+			name, ok = ei.Path(), true
+		}
+		b.mu.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case <-b.done:
+			return
+		case b.events <- fsnotify.Event{Name: name, Op: notifyOp(ei.Event())}:
+		}
+	}
+}
+
+func notifyOp(e notify.Event) fsnotify.Op {
+	var op fsnotify.Op
+	if e&notify.Create != 0 {
+		op |= fsnotify.Create
+	}
+	if e&notify.Write != 0 {
+		op |= fsnotify.Write
+	}
+	if e&notify.Remove != 0 {
+		op |= fsnotify.Remove
+	}
+	if e&notify.Rename != 0 {
+		op |= fsnotify.Rename
+	}
+	return op
+}
+
+// isWithin reports whether path is dir or is nested inside it.
+func isWithin(path, dir string) bool {
+	path, dir = pathKey(path), pathKey(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,121 @@
+package watcher
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// HighlightRule colors the lines of the program's output that match
+// Pattern. Style is a list of color names (red, green, yellow, blue,
+// magenta, cyan, white, black) and attributes (bold, dim, italic,
+// underline) separated by commas, spaces or plus signs. The levels error,
+// warn, info and debug can be used as shorthands for common styles.
+type HighlightRule struct {
+	Pattern string
+	Style   string
+}
+
+type highlightRule struct {
+	re    *regexp.Regexp
+	color *color.Color
+}
+
+var styleAttributes = map[string][]color.Attribute{
+	"black":     {color.FgBlack},
+	"red":       {color.FgRed},
+	"green":     {color.FgGreen},
+	"yellow":    {color.FgYellow},
+	"blue":      {color.FgBlue},
+	"magenta":   {color.FgMagenta},
+	"cyan":      {color.FgCyan},
+	"white":     {color.FgWhite},
+	"bold":      {color.Bold},
+	"dim":       {color.Faint},
+	"italic":    {color.Italic},
+	"underline": {color.Underline},
+	"error":     {color.FgRed, color.Bold},
+	"warn":      {color.FgYellow},
+	"info":      {color.FgCyan},
+	"debug":     {color.Faint},
+}
+
+func compileHighlightRules(rules []HighlightRule) ([]highlightRule, error) {
+	compiled := make([]highlightRule, 0, len(rules))
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid highlight pattern %q: %w", r.Pattern, err)
+		}
+		var attrs []color.Attribute
+		for _, name := range strings.FieldsFunc(strings.ToLower(r.Style), isStyleSeparator) {
+			a, ok := styleAttributes[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown highlight style %q in rule %q", name, r.Pattern)
+			}
+			attrs = append(attrs, a...)
+		}
+		compiled = append(compiled, highlightRule{re: re, color: color.New(attrs...)})
+	}
+	return compiled, nil
+}
+
+func isStyleSeparator(r rune) bool {
+	return r == ',' || r == '+' || r == ' '
+}
+
+// lineProcessor filters and colors the lines of program output. Lines that
+// do not match grep or that match grepV are dropped and the first matching
+// highlight rule colors the rest. Everything after a panic or fatal error
+// is passed through untouched: the program is about to exit and its trace
+// should neither be muted nor recolored.
+type lineProcessor struct {
+	*lineWriter
+	out         io.Writer
+	grep, grepV *regexp.Regexp
+	rules       []highlightRule
+	dying       bool
+}
+
+func newLineProcessor(out io.Writer, grep, grepV *regexp.Regexp, rules []highlightRule) *lineProcessor {
+	p := &lineProcessor{out: out, grep: grep, grepV: grepV, rules: rules}
+	p.lineWriter = newLineWriter(p.line)
+	return p
+}
+
+func (p *lineProcessor) line(line string) {
+	if isPanicStart(line) {
+		p.dying = true
+	}
+	if p.dying {
+		io.WriteString(p.out, line)
+		return
+	}
+	text := strings.TrimRight(line, "\r\n")
+	if !p.keep(text) {
+		return
+	}
+	for _, r := range p.rules {
+		if r.re.MatchString(text) {
+			io.WriteString(p.out, r.color.Sprint(text)+line[len(text):])
+			return
+		}
+	}
+	io.WriteString(p.out, line)
+}
+
+func (p *lineProcessor) keep(text string) bool {
+	if p.grep != nil && !p.grep.MatchString(text) {
+		return false
+	}
+	return p.grepV == nil || !p.grepV.MatchString(text)
+}
+
+// isPanicStart reports whether line is the first line of a panic or a fatal
+// runtime error.
+func isPanicStart(line string) bool {
+	return strings.HasPrefix(line, "panic: ") || strings.HasPrefix(line, "fatal error: ")
+}
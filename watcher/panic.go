@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Panic describes a panic or fatal error found in the output of the
+// program. File and Line point at the top frame of the panicking goroutine
+// that belongs to the watched directory, if there is one.
+type Panic struct {
+	Message  string
+	Function string
+	File     string
+	Line     int
+}
+
+var (
+	panicColor     = color.New(color.FgRed, color.Bold)
+	goroutineColor = color.New(color.FgCyan)
+	userFrameColor = color.New(color.FgYellow, color.Bold)
+	foldedColor    = color.New(color.Faint)
+)
+
+// panicHighlighter processes the program's stderr line by line, passing it
+// through to out unchanged except for panics and goroutine traces, which
+// are colored. Runtime frames are folded and the first frame within dir is
+// emphasized and reported to onPanic.
+type panicHighlighter struct {
+	*lineWriter
+	out     io.Writer
+	dir     string
+	onPanic func(Panic)
+
+	inTrace    bool
+	goroutines int
+	pending    string // function line waiting for its location line
+	folded     int
+	p          Panic
+}
+
+func newPanicHighlighter(out io.Writer, dir string, onPanic func(Panic)) *panicHighlighter {
+	h := &panicHighlighter{out: out, dir: dir, onPanic: onPanic}
+	h.lineWriter = newLineWriter(h.line)
+	return h
+}
+
+// Flush processes any partial line and ends the trace in progress.
+func (h *panicHighlighter) Flush() {
+	h.lineWriter.Flush()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.endTrace()
+}
+
+func (h *panicHighlighter) line(line string) {
+	text := strings.TrimRight(line, "\r\n")
+	if isPanicStart(text) {
+		h.endTrace()
+		h.inTrace = true
+		h.p = Panic{Message: text}
+		fmt.Fprintln(h.out, panicColor.Sprint(text))
+		return
+	}
+	if !h.inTrace {
+		io.WriteString(h.out, line)
+		return
+	}
+	switch {
+	case text == "":
+		h.flushPending()
+		io.WriteString(h.out, line)
+	case strings.HasPrefix(text, "goroutine ") && strings.HasSuffix(text, ":"):
+		h.flushPending()
+		h.goroutines++
+		fmt.Fprintln(h.out, goroutineColor.Sprint(text))
+	case strings.HasPrefix(text, "\t") && h.pending != "":
+		h.frame(h.pending, text)
+		h.pending = ""
+	case strings.HasPrefix(text, "\t"):
+		// Nested panics are listed indented below the first one.
+		fmt.Fprintln(h.out, panicColor.Sprint(text))
+	case strings.HasSuffix(text, ")") || strings.HasPrefix(text, "created by "):
+		h.flushPending()
+		h.pending = text
+	default:
+		h.endTrace()
+		io.WriteString(h.out, line)
+	}
+}
+
+func (h *panicHighlighter) frame(function, location string) {
+	if strings.HasPrefix(function, "runtime.") || strings.HasPrefix(function, "panic(") {
+		h.folded++
+		return
+	}
+	h.flushFolded()
+	file, line := parseLocation(location)
+	if h.goroutines == 1 && h.p.File == "" && isWithin(file, h.dir) {
+		h.p.Function, h.p.File, h.p.Line = function, file, line
+		fmt.Fprintln(h.out, userFrameColor.Sprint(function))
+		fmt.Fprintln(h.out, userFrameColor.Sprint(location))
+		return
+	}
+	fmt.Fprintln(h.out, function)
+	fmt.Fprintln(h.out, location)
+}
+
+func (h *panicHighlighter) flushPending() {
+	h.flushFolded()
+	if h.pending != "" {
+		fmt.Fprintln(h.out, h.pending)
+		h.pending = ""
+	}
+}
+
+func (h *panicHighlighter) flushFolded() {
+	if h.folded > 0 {
+		fmt.Fprintln(h.out, foldedColor.Sprintf("\t... %d runtime frame(s)", h.folded))
+		h.folded = 0
+	}
+}
+
+func (h *panicHighlighter) endTrace() {
+	if !h.inTrace {
+		return
+	}
+	h.flushPending()
+	h.inTrace = false
+	h.goroutines = 0
+	h.onPanic(h.p)
+	h.p = Panic{}
+}
+
+// parseLocation parses the location line of a stack frame, such as
+// "\t/src/main.go:12 +0x1d".
+func parseLocation(location string) (file string, line int) {
+	location = strings.TrimSpace(location)
+	if i := strings.LastIndex(location, " +0x"); i >= 0 {
+		location = location[:i]
+	}
+	i := strings.LastIndex(location, ":")
+	if i < 0 {
+		return location, 0
+	}
+	line, _ = strconv.Atoi(location[i+1:])
+	return location[:i], line
+}
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// caseInsensitive reports whether paths compare case-insensitively on this
+// platform, as they do on the default file systems of macOS and Windows.
+var caseInsensitive = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// pathKey returns the form of path used to compare it with other paths.
+// Events can arrive with a different casing than the registered path on
+// case-insensitive file systems, so paths are folded to lower case there.
+func pathKey(path string) string {
+	if caseInsensitive {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// globFiles expands the glob patterns in fsys and returns the absolute,
+// cleaned paths of the matches. Patterns may use forward slashes on every
+// platform, and "**" elements, which match any number of directories.
+func globFiles(fsys FS, patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		abs, err := filepath.Abs(filepath.FromSlash(pattern))
+		if err != nil {
+			return nil, err
+		}
+		matches, err := glob(fsys, abs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// maxGlobDepth bounds the number of directories a "**" element matches.
+const maxGlobDepth = 32
+
+// glob is fsys.Glob with support for "**" elements, which are expanded one
+// directory level at a time until a level is empty. A trailing "**" matches
+// the files under the directory but not the directories themselves.
+func glob(fsys FS, pattern string) ([]string, error) {
+	sep := string(filepath.Separator)
+	i := strings.Index(pattern, sep+"**")
+	if i < 0 || !(len(pattern) == i+3 || pattern[i+3:i+4] == sep) {
+		return fsys.Glob(pattern)
+	}
+	prefix, suffix := pattern[:i+1], strings.TrimPrefix(pattern[i+3:], sep)
+	filesOnly := suffix == ""
+	if filesOnly {
+		suffix = "*"
+	}
+	var files []string
+	for depth := 0; depth < maxGlobDepth; depth++ {
+		level := prefix + strings.Repeat("*"+sep, depth)
+		matches, err := glob(fsys, level+suffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if !filesOnly || !isDir(fsys, m) {
+				files = append(files, m)
+			}
+		}
+		if entries, _ := fsys.Glob(level + "*"); len(entries) == 0 {
+			break
+		}
+	}
+	return files, nil
+}
+
+// isDir reports whether name is a directory on disk. The files of other
+// file systems are never directories.
+func isDir(fsys FS, name string) bool {
+	if _, ok := fsys.(osFS); !ok {
+		return false
+	}
+	fi, err := os.Stat(name)
+	return err == nil && fi.IsDir()
+}
+
+// matchGlob reports whether the absolute path name matches one of the glob
+// patterns, which are relative to the working directory and may contain
+// "**" elements.
+func matchGlob(patterns []string, name string) bool {
+	elems := strings.Split(filepath.ToSlash(pathKey(name)), "/")
+	for _, pattern := range patterns {
+		abs, err := filepath.Abs(filepath.FromSlash(pattern))
+		if err != nil {
+			continue
+		}
+		if matchElems(strings.Split(filepath.ToSlash(pathKey(abs)), "/"), elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// inModule reports whether importPath belongs to the module with the given
+// path, treating the module path as a whole path prefix so that "a/b" does
+// not contain "a/bc".
+func inModule(importPath, modPath string) bool {
+	return importPath == modPath || strings.HasPrefix(importPath, modPath+"/")
+}
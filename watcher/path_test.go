@@ -0,0 +1,133 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// tempTree creates the files, given with forward slashes, under a new
+// temporary directory and returns its path.
+func tempTree(t *testing.T, files ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, name := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestGlobFiles(t *testing.T) {
+	root := tempTree(t, "top.tmpl", "a/x.tmpl", "a/skip.go", "a/b/y.tmpl", "a/b/c/z.tmpl")
+	slashed := filepath.ToSlash(root)
+	for _, tc := range []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{"slash", slashed + "/a/*.tmpl", []string{"a/x.tmpl"}},
+		{"native", filepath.Join(root, "a", "*.tmpl"), []string{"a/x.tmpl"}},
+		{"double star", slashed + "/a/**/*.tmpl", []string{"a/b/c/z.tmpl", "a/b/y.tmpl", "a/x.tmpl"}},
+		{"native double star", filepath.Join(root, "**", "*.tmpl"), []string{"a/b/c/z.tmpl", "a/b/y.tmpl", "a/x.tmpl", "top.tmpl"}},
+		{"trailing double star", slashed + "/a/b/**", []string{"a/b/c/z.tmpl", "a/b/y.tmpl"}},
+		{"no match", slashed + "/a/**/*.css", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := globFiles(osFS{}, []string{tc.pattern})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range files {
+				if !filepath.IsAbs(f) {
+					t.Errorf("%s is not absolute", f)
+				}
+				rel, err := filepath.Rel(root, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("globFiles(%q) = %q, want %q", tc.pattern, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	root, err := filepath.Abs(filepath.FromSlash("/mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slashed := filepath.ToSlash(root)
+	for _, tc := range []struct {
+		pattern string
+		file    string
+		want    bool
+	}{
+		{slashed + "/config/*.yaml", "config/app.yaml", true},
+		{slashed + "/config/*.yaml", "config/dev/app.yaml", false},
+		{slashed + "/templates/**", "templates/a/b/page.html", true},
+		{slashed + "/templates/**/*.html", "templates/page.html", true},
+		{slashed + "/templates/**/*.html", "static/page.html", false},
+		{filepath.Join(root, "**", "*.sql"), "db/migrations/1.sql", true},
+		// Files outside of the module match absolute patterns only.
+		{slashed + "/**/*.yaml", "../other/app.yaml", false},
+	} {
+		file := filepath.Join(root, filepath.FromSlash(tc.file))
+		if got := matchGlob([]string{tc.pattern}, file); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, file, got, tc.want)
+		}
+	}
+}
+
+// TestMatchGlobBackslash checks that backslashes separate the elements of
+// patterns on Windows only. Elsewhere, they escape the next character, as
+// in filepath.Match.
+func TestMatchGlobBackslash(t *testing.T) {
+	root, err := filepath.Abs(filepath.FromSlash("/mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows := runtime.GOOS == "windows"
+	pattern := root + `\config\*.yaml`
+	for _, tc := range []struct {
+		file string
+		want bool
+	}{
+		{filepath.Join(root, "config", "app.yaml"), windows},
+		{root + `\config\app.yaml`, windows},
+	} {
+		if got := matchGlob([]string{pattern}, tc.file); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", pattern, tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestInModule(t *testing.T) {
+	for _, tc := range []struct {
+		importPath, modPath string
+		want                bool
+	}{
+		{"example.com/a", "example.com/a", true},
+		{"example.com/a/b", "example.com/a", true},
+		{"example.com/ab", "example.com/a", false},
+		{"example.com", "example.com/a", false},
+		{"other.com/a", "example.com/a", false},
+	} {
+		if got := inModule(tc.importPath, tc.modPath); got != tc.want {
+			t.Errorf("inModule(%q, %q) = %v, want %v", tc.importPath, tc.modPath, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package watcher
+
+import "os"
+
+// pauseSignals is empty on platforms without user defined signals.
+var pauseSignals []os.Signal
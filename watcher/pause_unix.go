@@ -0,0 +1,11 @@
+//go:build unix
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// pauseSignals toggle pausing restarts when Config.PauseSignal is set.
+var pauseSignals = []os.Signal{syscall.SIGUSR1}
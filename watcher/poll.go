@@ -0,0 +1,158 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultPollInterval = time.Second
+
+// networkPollInterval is the default interval of the poll backend when it is
+// selected because of a network file system, where every check is a round
+// trip.
+const networkPollInterval = 2 * time.Second
+
+// pollBackend is a Backend that periodically stats every added file and
+// synthesizes events for the ones that changed, comparing the content of
+// the recently modified ones. It works on file systems where native
+// notifications are unavailable, such as network mounts.
+type pollBackend struct {
+	interval time.Duration
+	clock    Clock
+	events   chan fsnotify.Event
+	errs     chan error
+	done     chan struct{}
+
+	mu    sync.Mutex
+	files map[string]fs.FileInfo // nil value means the file does not exist
+	// sums holds the hash of the files modified within mtimeGranularity
+	// of when they were stat'ed.
+	sums map[string][sha256.Size]byte
+}
+
+// mtimeGranularity is the coarsest resolution of modification times among
+// the file systems polled, such as FAT or some network mounts. A file
+// modified that recently may change again without a new modification time,
+// so its content is compared.
+const mtimeGranularity = 2 * time.Second
+
+func newPollBackend(interval time.Duration, clock Clock) *pollBackend {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	b := &pollBackend{
+		interval: interval,
+		clock:    clock,
+		events:   make(chan fsnotify.Event),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+		files:    map[string]fs.FileInfo{},
+		sums:     map[string][sha256.Size]byte{},
+	}
+	go b.poll()
+	return b
+}
+
+func (b *pollBackend) Add(name string) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.files[name] = fi
+	b.hash(name, fi)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *pollBackend) Remove(name string) error {
+	b.mu.Lock()
+	delete(b.files, name)
+	delete(b.sums, name)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *pollBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *pollBackend) Errors() <-chan error          { return b.errs }
+
+func (b *pollBackend) Close() error {
+	close(b.done)
+	return nil
+}
+
+func (b *pollBackend) poll() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.clock.After(b.interval):
+		}
+		for _, event := range b.scan() {
+			select {
+			case <-b.done:
+				return
+			case b.events <- event:
+			}
+		}
+	}
+}
+
+// scan stats every file and returns the events for the ones that changed
+// since the previous scan.
+func (b *pollBackend) scan() []fsnotify.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []fsnotify.Event
+	for name, prev := range b.files {
+		fi, err := os.Stat(name)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if prev != nil {
+				events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+			}
+			fi = nil
+		case err != nil:
+			continue
+		case prev == nil:
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Create})
+		case !fi.ModTime().Equal(prev.ModTime()) || fi.Size() != prev.Size():
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+		default:
+			old, ok := b.sums[name]
+			if ok && b.hash(name, fi) && b.sums[name] != old {
+				events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+			}
+			continue
+		}
+		b.files[name] = fi
+		if fi == nil {
+			delete(b.sums, name)
+		} else {
+			b.hash(name, fi)
+		}
+	}
+	return events
+}
+
+// hash records the hash of the file name if it was modified too recently
+// for its modification time to tell whether it changed, and reports
+// whether it did.
+func (b *pollBackend) hash(name string, fi fs.FileInfo) bool {
+	delete(b.sums, name)
+	if fi.IsDir() || b.clock.Now().Sub(fi.ModTime()) > mtimeGranularity {
+		return false
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	b.sums[name] = sha256.Sum256(data)
+	return true
+}
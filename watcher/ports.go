@@ -0,0 +1,69 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// portPollInterval is how often a port is checked while waiting for it
+	// to be released.
+	portPollInterval = 50 * time.Millisecond
+	// portWaitTimeout is how long to wait for a port before starting the
+	// program anyway, letting it report the error.
+	portWaitTimeout = 10 * time.Second
+)
+
+// ports returns the ports the program listens on: the WaitForPorts and the
+// port of the ProxyTarget.
+func (w *watcher) ports() []int {
+	ports := w.c.WaitForPorts
+	if _, p, err := net.SplitHostPort(w.c.ProxyTarget); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			ports = append(ports[:len(ports):len(ports)], port)
+		}
+	}
+	return ports
+}
+
+// waitForPorts waits until the ports of the program can be listened on,
+// once the previous program released them, or until portWaitTimeout.
+func (w *watcher) waitForPorts(ctx context.Context) error {
+	var deadline <-chan time.Time
+	for _, port := range w.ports() {
+		logged := false
+		for !portFree(port) {
+			if deadline == nil {
+				deadline = w.c.Clock.After(portWaitTimeout)
+			}
+			if !logged {
+				w.c.Logf(color.YellowString("waiting for port %d to be released", port))
+				logged = true
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-deadline:
+				w.c.Logf(color.YellowString("port %d is still in use after %v, starting anyway", port, portWaitTimeout))
+				return nil
+			case <-w.c.Clock.After(portPollInterval):
+			}
+		}
+	}
+	return nil
+}
+
+// portFree reports whether a program can listen on port.
+func portFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
@@ -0,0 +1,43 @@
+//go:build !unix && !windows
+
+package watcher
+
+import (
+	"os"
+	"os/exec"
+)
+
+// isolate does nothing on platforms without process groups.
+func isolate(cmd *exec.Cmd) {}
+
+// defaultKillTimeout is the KillTimeout when none is set: programs are
+// waited for until they exit.
+const defaultKillTimeout = 0
+
+// killTree kills p.
+func killTree(p *os.Process) error {
+	return p.Kill()
+}
+
+// interrupt asks p to stop.
+func interrupt(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+// procGroup only holds the program on platforms without process groups.
+type procGroup struct {
+	p *os.Process
+}
+
+func newProcGroup(p *os.Process) (*procGroup, error) {
+	return &procGroup{p: p}, nil
+}
+
+func (g *procGroup) signal(sig os.Signal) error {
+	if sig == os.Interrupt {
+		return interrupt(g.p)
+	}
+	return g.p.Signal(sig)
+}
+
+func (g *procGroup) close() {}
@@ -0,0 +1,63 @@
+//go:build unix
+
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolate starts cmd in its own process group, so that the interrupt of a
+// Ctrl-C in the terminal only reaches gowatch, which then stops cmd along
+// with the processes it spawned.
+func isolate(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// defaultKillTimeout is the KillTimeout when none is set: programs are
+// waited for until they exit.
+const defaultKillTimeout = 0
+
+// killTree kills p. The processes it spawned are killed with its group.
+func killTree(p *os.Process) error {
+	return p.Kill()
+}
+
+// interrupt asks p to stop.
+func interrupt(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+// procGroup is the process group of a started program, which contains the
+// processes it spawned.
+type procGroup struct {
+	pgid int
+}
+
+func newProcGroup(p *os.Process) (*procGroup, error) {
+	return &procGroup{pgid: p.Pid}, nil
+}
+
+// signal sends sig to every process of the group.
+func (g *procGroup) signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", sig)
+	}
+	err := syscall.Kill(-g.pgid, s)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// close kills the processes left in the group once the program exited.
+func (g *procGroup) close() {
+	syscall.Kill(-g.pgid, syscall.SIGKILL)
+}
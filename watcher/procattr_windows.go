@@ -0,0 +1,101 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// isolate starts cmd in its own process group, so that a Ctrl-C in the
+// console only reaches gowatch and cmd can be sent a CTRL_BREAK of its own.
+func isolate(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// defaultKillTimeout is the KillTimeout when none is set. Programs that do
+// not handle CTRL_BREAK would otherwise keep running, holding their ports,
+// so they are killed after a while.
+const defaultKillTimeout = 5 * time.Second
+
+// interrupt asks p to stop with a CTRL_BREAK event, which Go programs
+// receive as os.Interrupt. Without a console, such as under a service
+// manager, p and its children are killed instead.
+func interrupt(p *os.Process) error {
+	if err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(p.Pid)); err != nil {
+		return killTree(p)
+	}
+	return nil
+}
+
+// killTree kills p and the processes it spawned with taskkill, or only p if
+// taskkill fails.
+func killTree(p *os.Process) error {
+	cmd := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid))
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	if err := cmd.Run(); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+// procGroup is the job object of a started program, which contains the
+// processes it spawns, in addition to the console process group it was
+// started in.
+type procGroup struct {
+	p   *os.Process
+	job windows.Handle
+}
+
+func newProcGroup(p *os.Process) (*procGroup, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("CreateJobObject: %w", err)
+	}
+	// Closing the job kills the processes left in it.
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		windows.CloseHandle(job)
+		return nil, fmt.Errorf("SetInformationJobObject: %w", err)
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(p.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return nil, fmt.Errorf("OpenProcess: %w", err)
+	}
+	defer windows.CloseHandle(h)
+	if err := windows.AssignProcessToJobObject(job, h); err != nil {
+		windows.CloseHandle(job)
+		return nil, fmt.Errorf("AssignProcessToJobObject: %w", err)
+	}
+	return &procGroup{p: p, job: job}, nil
+}
+
+// signal interrupts every process of the console process group, or kills
+// every process of the job.
+func (g *procGroup) signal(sig os.Signal) error {
+	if sig == os.Kill {
+		return windows.TerminateJobObject(g.job, 1)
+	}
+	if sig == os.Interrupt {
+		return interrupt(g.p)
+	}
+	return g.p.Signal(sig)
+}
+
+// close kills the processes left in the job once the program exited.
+func (g *procGroup) close() {
+	windows.CloseHandle(g.job)
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package watcher
+
+import "os"
+
+// processAlive reports whether a process with the given id exists. Finding
+// a process only fails on Windows, other platforms assume it exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,136 @@
+package watcher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+const spinnerInterval = 100 * time.Millisecond
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// spinner draws an animated status line on a terminal until it is stopped.
+// Writes to the spinner clear the line first, so that output can be
+// interleaved with it.
+type spinner struct {
+	out   io.Writer
+	clock Clock
+	msg   string
+	start time.Time
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu    sync.Mutex
+	frame int
+	drawn bool // whether the status line is on screen
+}
+
+// startSpinner starts a spinner on out if it is a terminal, and returns nil
+// otherwise. The methods of a nil *spinner write straight to out.
+func startSpinner(out io.Writer, clock Clock, msg string) *spinner {
+	f, ok := out.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	s := &spinner{
+		out:   out,
+		clock: clock,
+		msg:   msg,
+		start: clock.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *spinner) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		s.draw()
+		s.mu.Unlock()
+		select {
+		case <-s.stop:
+			return
+		case <-s.clock.After(spinnerInterval):
+		}
+	}
+}
+
+// draw must be called with s.mu held.
+func (s *spinner) draw() {
+	elapsed := s.clock.Now().Sub(s.start).Round(100 * time.Millisecond)
+	frame := spinnerFrames[s.frame%len(spinnerFrames)]
+	s.frame++
+	fmt.Fprintf(s.out, "\r%s %s %s\x1b[K", color.CyanString(frame), s.msg, color.New(color.Faint).Sprint(elapsed))
+	s.drawn = true
+}
+
+func (s *spinner) clear() {
+	if s.drawn {
+		io.WriteString(s.out, "\r\x1b[K")
+		s.drawn = false
+	}
+}
+
+// writer returns a writer to out that clears the status line before
+// writing.
+func (s *spinner) writer(out io.Writer) io.Writer {
+	if s == nil {
+		return out
+	}
+	return spinnerWriter{s, out}
+}
+
+type spinnerWriter struct {
+	s   *spinner
+	out io.Writer
+}
+
+func (w spinnerWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	w.s.clear()
+	return w.out.Write(p)
+}
+
+// Stop stops the spinner and clears its line.
+func (s *spinner) Stop() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clear()
+}
+
+// cycleTimings is how long each phase of a cycle took.
+type cycleTimings struct {
+	scan, stop, build, start time.Duration
+}
+
+func (t cycleTimings) String() string {
+	var parts []string
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{{"scan", t.scan}, {"stop", t.stop}, {"build", t.build}, {"start", t.start}} {
+		switch {
+		case p.d >= time.Millisecond:
+			parts = append(parts, fmt.Sprintf("%s %v", p.name, p.d.Round(time.Millisecond)))
+		case p.d > 0:
+			parts = append(parts, fmt.Sprintf("%s %v", p.name, p.d.Round(time.Microsecond)))
+		}
+	}
+	return strings.Join(parts, " · ")
+}
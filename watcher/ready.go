@@ -0,0 +1,284 @@
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// readyCheckEvery is how often the ReadyCheck of the program is probed
+	// until it succeeds.
+	readyCheckEvery = 100 * time.Millisecond
+	// readyProbeTimeout bounds a single probe of the ReadyCheck.
+	readyProbeTimeout = time.Second
+)
+
+// parseReadyCheck parses the ReadyCheck of a config, nil if unset.
+func parseReadyCheck(check string) (*url.URL, error) {
+	if check == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(check)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ready check: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp", "http", "https", "grpc":
+	default:
+		return nil, fmt.Errorf("invalid ready check %q, want a tcp://, http://, https:// or grpc:// URL", check)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("ready check %q has no address", check)
+	}
+	return u, nil
+}
+
+// waitReady probes the ReadyCheck of the program started at started until
+// it succeeds, and then reports the program as ready to the targets
+// depending on it, or until ctx is done as the program exited.
+func (w *watcher) waitReady(ctx context.Context, started time.Time) {
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, readyProbeTimeout)
+		err := probe(probeCtx, w.readyCheck)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			w.c.Logf(color.GreenString("✓ ready in %v", w.c.Clock.Now().Sub(started).Round(time.Millisecond)))
+			w.opts.deps.done(w.c.Name)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.c.Clock.After(readyCheckEvery):
+		}
+	}
+}
+
+// probe checks once whether the service of check is ready: whether it
+// accepts TCP connections, answers an HTTP GET with a 2xx or 3xx status, or
+// reports itself as serving through the gRPC health service.
+func probe(ctx context.Context, check *url.URL) error {
+	switch check.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		return nil
+	case "grpc":
+		return grpcHealthCheck(ctx, check.Host, strings.TrimPrefix(check.Path, "/"))
+	default:
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", check.Host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// The HTTP/2 frames and flags grpcHealthCheck uses.
+const (
+	frameData      = 0x0
+	frameHeaders   = 0x1
+	frameRSTStream = 0x3
+	frameSettings  = 0x4
+	framePing      = 0x6
+	frameGoAway    = 0x7
+
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+)
+
+// grpcServing is the SERVING status of a grpc.health.v1 response.
+const grpcServing = 1
+
+// grpcHealthCheck calls the Check method of the grpc.health.v1.Health
+// service of the plaintext gRPC server at addr, for service or for the whole
+// server if empty, and returns an error unless the server is serving. It
+// speaks just enough HTTP/2 for this single call, so that gowatch does not
+// depend on a gRPC implementation.
+func grpcHealthCheck(ctx context.Context, addr, service string) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var req bytes.Buffer
+	req.WriteString("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	writeH2Frame(&req, frameSettings, 0, 0, nil)
+	var headers []byte
+	for _, h := range [][2]string{
+		{":method", "POST"},
+		{":scheme", "http"},
+		{":path", "/grpc.health.v1.Health/Check"},
+		{":authority", addr},
+		{"content-type", "application/grpc"},
+		{"te", "trailers"},
+	} {
+		headers = hpackLiteral(headers, h[0], h[1])
+	}
+	writeH2Frame(&req, frameHeaders, flagEndHeaders, 1, headers)
+	// The HealthCheckRequest has the service as its field 1.
+	var msg []byte
+	if service != "" {
+		msg = append(binary.AppendUvarint([]byte{1<<3 | 2}, uint64(len(service))), service...)
+	}
+	writeH2Frame(&req, frameData, flagEndStream, 1, grpcMessage(msg))
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	var body []byte
+	for {
+		typ, flags, stream, payload, err := readH2Frame(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case typ == frameSettings && flags&flagAck == 0:
+			writeH2Frame(conn, frameSettings, flagAck, 0, nil)
+		case typ == framePing && flags&flagAck == 0:
+			writeH2Frame(conn, framePing, flagAck, 0, payload)
+		case typ == frameGoAway:
+			return errors.New("the server closed the connection")
+		case typ == frameRSTStream && stream == 1:
+			return errors.New("the server reset the health check")
+		case typ == frameData && stream == 1:
+			if flags&flagPadded != 0 && len(payload) > 0 {
+				pad := int(payload[0])
+				if pad >= len(payload) {
+					return errors.New("invalid padding")
+				}
+				payload = payload[1 : len(payload)-pad]
+			}
+			body = append(body, payload...)
+		}
+		if stream == 1 && flags&flagEndStream != 0 && (typ == frameData || typ == frameHeaders) {
+			break
+		}
+	}
+	status, err := healthStatus(body)
+	if err != nil {
+		return err
+	}
+	if status != grpcServing {
+		return fmt.Errorf("health status %d, want SERVING", status)
+	}
+	return nil
+}
+
+// writeH2Frame writes the HTTP/2 frame of typ, flags and payload on stream.
+func writeH2Frame(w io.Writer, typ, flags byte, stream uint32, payload []byte) error {
+	var header [9]byte
+	header[0], header[1], header[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	header[3], header[4] = typ, flags
+	binary.BigEndian.PutUint32(header[5:], stream)
+	_, err := w.Write(append(header[:], payload...))
+	return err
+}
+
+// readH2Frame reads an HTTP/2 frame from r.
+func readH2Frame(r io.Reader) (typ, flags byte, stream uint32, payload []byte, err error) {
+	var header [9]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	payload = make([]byte, int(header[0])<<16|int(header[1])<<8|int(header[2]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	return header[3], header[4], binary.BigEndian.Uint32(header[5:]) &^ (1 << 31), payload, nil
+}
+
+// hpackLiteral appends the header field of name and value to b, as an HPACK
+// literal without indexing and without Huffman coding, which every decoder
+// accepts.
+func hpackLiteral(b []byte, name, value string) []byte {
+	b = append(b, 0)
+	for _, s := range []string{name, value} {
+		b = hpackLength(b, len(s))
+		b = append(b, s...)
+	}
+	return b
+}
+
+// hpackLength appends n to b as an HPACK integer with a 7-bit prefix.
+func hpackLength(b []byte, n int) []byte {
+	if n < 127 {
+		return append(b, byte(n))
+	}
+	b = append(b, 127)
+	for n -= 127; n >= 128; n /= 128 {
+		b = append(b, byte(n%128+128))
+	}
+	return append(b, byte(n))
+}
+
+// grpcMessage returns msg with the prefix of an uncompressed gRPC message.
+func grpcMessage(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// healthStatus returns the status, field 1 of the HealthCheckResponse, of
+// the gRPC message body.
+func healthStatus(body []byte) (uint64, error) {
+	if len(body) < 5 || body[0] != 0 {
+		return 0, errors.New("no health check response")
+	}
+	msg := body[5:]
+	n := int(binary.BigEndian.Uint32(body[1:]))
+	if n > len(msg) {
+		return 0, errors.New("truncated health check response")
+	}
+	msg = msg[:n]
+	// The status is 0, UNKNOWN, when the response omits it.
+	var status uint64
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 || key&7 != 0 {
+			return 0, errors.New("invalid health check response")
+		}
+		v, m := binary.Uvarint(msg[n:])
+		if m <= 0 {
+			return 0, errors.New("invalid health check response")
+		}
+		if key>>3 == 1 {
+			status = v
+		}
+		msg = msg[n+m:]
+	}
+	return status, nil
+}
@@ -0,0 +1,191 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// recordedEvent is a single line of an events log written by Config.Record
+// and read by Config.Replay. Offset is the time elapsed since the watcher
+// started.
+type recordedEvent struct {
+	Offset time.Duration `json:"offset"`
+	Name   string        `json:"name"`
+	Op     string        `json:"op"`
+}
+
+type recorder struct {
+	f     *os.File
+	clock Clock
+	start time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newRecorder(path string, clock Clock) (*recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Create: %w", err)
+	}
+	return &recorder{f: f, enc: json.NewEncoder(f), clock: clock, start: clock.Now()}, nil
+}
+
+func (r *recorder) record(event fsnotify.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(recordedEvent{
+		Offset: r.clock.Now().Sub(r.start),
+		Name:   event.Name,
+		Op:     event.Op.String(),
+	})
+}
+
+func (r *recorder) Close() error {
+	return r.f.Close()
+}
+
+// replayBuffer is how many replayed events wait for the watch loop, so
+// that the replay keeps to the recorded times while it builds.
+const replayBuffer = 1024
+
+// replayBackend is a Backend that emits the events of a recorded events
+// log instead of watching the file system. Like fsnotifyBackend, it only
+// emits the events of the added files and of the files directly inside the
+// added directories.
+type replayBackend struct {
+	events chan fsnotify.Event
+	errs   chan error
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	added map[string]string // pathKey of the added paths to their name
+	dirs  map[string]bool   // pathKey of the added directories
+}
+
+func newReplayBackend(path string, clock Clock, logf func(string, ...any)) *replayBackend {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &replayBackend{
+		events: make(chan fsnotify.Event, replayBuffer),
+		errs:   make(chan error, 1),
+		cancel: cancel,
+		added:  map[string]string{},
+		dirs:   map[string]bool{},
+	}
+	go func() {
+		err := replayEvents(ctx, path, clock, n.match, n.events)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				n.errs <- fmt.Errorf("replay: %w", err)
+			}
+			return
+		}
+		logf("finished replaying %v", path)
+	}()
+	return n
+}
+
+// Add adds name, which is a file unless it is an existing directory.
+func (n *replayBackend) Add(name string) error {
+	fi, err := os.Stat(name)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.added[pathKey(name)] = name
+	n.dirs[pathKey(name)] = err == nil && fi.IsDir()
+	return nil
+}
+
+func (n *replayBackend) Remove(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.added, pathKey(name))
+	delete(n.dirs, pathKey(name))
+	return nil
+}
+
+// match returns the name the event of the file named name is emitted
+// with, and whether it is emitted.
+func (n *replayBackend) match(name string) (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return addedName(n.added, n.dirs, name)
+}
+
+func (n *replayBackend) Events() <-chan fsnotify.Event { return n.events }
+func (n *replayBackend) Errors() <-chan error          { return n.errs }
+
+func (n *replayBackend) Close() error {
+	n.cancel()
+	return nil
+}
+
+// replayEvents reads the events log at path and sends the events that
+// match, under the name match returns, on events at the offset they were
+// recorded at since the replay started.
+func replayEvents(ctx context.Context, path string, clock Clock, match func(string) (string, bool), events chan<- fsnotify.Event) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer f.Close()
+	start := clock.Now()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var re recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &re); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		op, err := parseOp(re.Op)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clock.After(re.Offset - clock.Now().Sub(start)):
+		}
+		name, ok := match(re.Name)
+		if !ok {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case events <- fsnotify.Event{Name: name, Op: op}:
+		}
+	}
+	return scanner.Err()
+}
+
+var opNames = map[string]fsnotify.Op{
+	"CREATE": fsnotify.Create,
+	"WRITE":  fsnotify.Write,
+	"REMOVE": fsnotify.Remove,
+	"RENAME": fsnotify.Rename,
+	"CHMOD":  fsnotify.Chmod,
+}
+
+// parseOp is the inverse of fsnotify.Op.String.
+func parseOp(s string) (fsnotify.Op, error) {
+	var op fsnotify.Op
+	if s == "" || s == "[no events]" {
+		return op, nil
+	}
+	for _, name := range strings.Split(s, "|") {
+		o, ok := opNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown event op %q", name)
+		}
+		op |= o
+	}
+	return op, nil
+}
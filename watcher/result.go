@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// BuildResult describes a build of the program.
+type BuildResult struct {
+	Success  bool          `json:"success"`
+	Duration time.Duration `json:"duration"`
+	// Output is everything the build printed to stdout and stderr.
+	Output      string       `json:"output"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// BinaryPath is the path of the built program. It is empty when the
+	// build failed or when Config.Build replaces the go build.
+	BinaryPath string `json:"binaryPath,omitempty"`
+	// GitSHA is the commit checked out in Config.Dir, if it is in a git
+	// repository.
+	GitSHA string `json:"gitSHA,omitempty"`
+	Err    error  `json:"-"`
+}
+
+// newBuildResult describes a build that printed output and returned err.
+func (w *watcher) newBuildResult(output string, duration time.Duration, err error) BuildResult {
+	r := BuildResult{
+		Success:     err == nil,
+		Duration:    duration,
+		Output:      output,
+		Diagnostics: parseDiagnostics(output),
+		Err:         err,
+	}
+	if r.Success && w.c.Build == "" {
+		r.BinaryPath = w.binpath
+	}
+	if out, err := git(w.c.Dir, "rev-parse", "HEAD"); err == nil {
+		r.GitSHA = strings.TrimSpace(string(out))
+	}
+	return r
+}
+
+// logBuild logs a line summing up the last build and, if started is set,
+// the start of the program it built, restarted if it replaced a running
+// one. The dashboard and the JSON log report builds themselves.
+func (w *watcher) logBuild(started, restarted bool) {
+	if w.tui != nil || w.c.LogFormat == LogFormatJSON {
+		return
+	}
+	r := w.lastBuild
+	d := r.Duration.Round(time.Millisecond)
+	if !r.Success {
+		msg := fmt.Sprintf("✗ build failed in %v", d)
+		switch n := len(r.Diagnostics); n {
+		case 0:
+		case 1:
+			msg += " (1 error)"
+		default:
+			msg += fmt.Sprintf(" (%d errors)", n)
+		}
+		w.c.Logf(color.RedString(msg))
+		return
+	}
+	msg := fmt.Sprintf("✓ built in %v", d)
+	if started {
+		verb := "started"
+		if restarted {
+			verb = "restarted"
+		}
+		msg += ", " + verb
+		if pid := pid(w.proc); pid != 0 {
+			msg += fmt.Sprintf(" (pid %d)", pid)
+		}
+	}
+	w.c.Logf(color.GreenString(msg))
+}
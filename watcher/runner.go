@@ -0,0 +1,180 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Cmd describes a command to be run by a Runner.
+type Cmd struct {
+	Name   string
+	Args   []string
+	Dir    string
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Process is a command started by a Runner.
+type Process interface {
+	Signal(sig os.Signal) error
+	// Wait waits for the process to exit. It must only be called once.
+	Wait() error
+}
+
+// Runner runs the build and the program being watched.
+type Runner interface {
+	// Run runs cmd and waits for it to complete.
+	Run(ctx context.Context, cmd Cmd) error
+	// Start starts cmd without waiting for it to complete. The process
+	// must be interrupted when ctx is done.
+	Start(ctx context.Context, cmd Cmd) (Process, error)
+}
+
+// execRunner runs commands with os/exec. With cgroups, every started
+// process gets its own cgroup, and the processes left in it are killed once
+// it exits. Started processes are sent stopSignal, an interrupt by default,
+// when ctx is done, and killed if they did not exit killTimeout later, if
+// set.
+type execRunner struct {
+	cgroups     bool
+	killTimeout time.Duration
+	stopSignal  os.Signal
+}
+
+func (execRunner) Run(ctx context.Context, c Cmd) error {
+	return command(ctx, c).Run()
+}
+
+func (r execRunner) Start(ctx context.Context, c Cmd) (Process, error) {
+	cmd := command(ctx, c)
+	// group is set once the process started, before which it cannot be
+	// canceled.
+	var group atomic.Pointer[procGroup]
+	cmd.Cancel = func() error {
+		return execProcess{cmd: cmd, group: group.Load()}.Signal(r.stopSignalOrInterrupt())
+	}
+	isolate(cmd)
+	cmd.WaitDelay = r.killTimeout
+	if !r.cgroups {
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		p := execProcess{cmd: cmd, group: startedGroup(cmd.Process)}
+		group.Store(p.group)
+		return p, nil
+	}
+	g, err := newCgroup()
+	if err != nil {
+		return nil, err
+	}
+	// Processes left in the cgroup may hold the output pipes open, don't
+	// wait for them once the program exited as they are killed anyway.
+	cmd.WaitDelay = cgroupWaitDelay
+	if err := g.start(cmd); err != nil {
+		g.close()
+		return nil, err
+	}
+	p := execProcess{cmd: cmd, cgroup: g, group: startedGroup(cmd.Process)}
+	group.Store(p.group)
+	return p, nil
+}
+
+func (r execRunner) stopSignalOrInterrupt() os.Signal {
+	if r.stopSignal == nil {
+		return os.Interrupt
+	}
+	return r.stopSignal
+}
+
+// startedGroup returns the process group of the started process p, or nil
+// if it cannot be set up, in which case only p is signaled.
+func startedGroup(p *os.Process) *procGroup {
+	g, err := newProcGroup(p)
+	if err != nil {
+		return nil
+	}
+	return g
+}
+
+const cgroupWaitDelay = 500 * time.Millisecond
+
+func command(ctx context.Context, c Cmd) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	return cmd
+}
+
+// execProcess is a process started by execRunner. Signals reach the
+// processes it spawned through its process group.
+type execProcess struct {
+	cmd    *exec.Cmd
+	cgroup *cgroup
+	group  *procGroup
+}
+
+func (p execProcess) Signal(sig os.Signal) error {
+	if p.group != nil {
+		return p.group.signal(sig)
+	}
+	switch sig {
+	case os.Interrupt:
+		return interrupt(p.cmd.Process)
+	case os.Kill:
+		return killTree(p.cmd.Process)
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p execProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// ResourceUsage returns the resources used by the processes of the cgroup
+// of p, with cgroups.
+func (p execProcess) ResourceUsage() (ResourceUsage, bool) {
+	if p.cgroup == nil {
+		return ResourceUsage{}, false
+	}
+	return p.cgroup.usage()
+}
+
+func (p execProcess) Wait() error {
+	err := p.cmd.Wait()
+	if p.group != nil {
+		p.group.close()
+	}
+	if p.cgroup == nil {
+		return err
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	if cerr := p.cgroup.close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+const (
+	startRetryDelay    = 10 * time.Millisecond
+	maxStartRetryDelay = 640 * time.Millisecond
+)
+
+// isTransientStartError reports whether starting a process failed because
+// the executable was still open for writing, or because of a temporary lack
+// of resources.
+func isTransientStartError(err error) bool {
+	return errors.Is(err, syscall.ETXTBSY) || errors.Is(err, syscall.EAGAIN)
+}
@@ -0,0 +1,156 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Sidecar is a long running helper process, such as a local S3 emulator or
+// a mail catcher, that gowatch starts once, restarts when it crashes and
+// stops when it exits, independently of the builds of the program.
+type Sidecar struct {
+	Name string
+	// Command is the command line of the sidecar.
+	Command string
+	// Dir is the working directory of the sidecar, relative to Config.Dir.
+	Dir string
+	// Env is added to the environment of the program for the sidecar.
+	Env []string
+	// HealthCheck is a command, such as "pg_isready -h localhost", that
+	// exits successfully once the sidecar is ready. It is run every second
+	// after the sidecar starts until it succeeds, for the Targets that
+	// depend on the sidecar. Without it, a sidecar is ready once started.
+	HealthCheck string
+}
+
+const (
+	sidecarMinBackoff = time.Second
+	sidecarMaxBackoff = 30 * time.Second
+	// sidecarStableAfter is how long a sidecar must run for its restart
+	// backoff to be reset.
+	sidecarStableAfter = 10 * time.Second
+	// sidecarHealthEvery is how often the HealthCheck of a sidecar runs
+	// until it succeeds.
+	sidecarHealthEvery = time.Second
+)
+
+// checkSidecars returns an error if a sidecar has no program to run, as it
+// would never be ready for the targets depending on it.
+func checkSidecars(sidecars []Sidecar) error {
+	for _, s := range sidecars {
+		if len(strings.Fields(s.Command)) == 0 {
+			return fmt.Errorf("sidecar %q has no program to run: %q", s.Name, s.Command)
+		}
+		if err := checkCommand(fmt.Sprintf("the HealthCheck of sidecar %q", s.Name), s.HealthCheck); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSidecars starts the Sidecars of the config and returns a function
+// that stops them and waits for them to exit.
+func (w *watcher) startSidecars(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, s := range w.c.Sidecars {
+		wg.Add(1)
+		go func(s Sidecar) {
+			defer wg.Done()
+			w.superviseSidecar(ctx, s)
+		}(s)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// superviseSidecar runs s until ctx is done, restarting it with an
+// exponential backoff when it exits.
+func (w *watcher) superviseSidecar(ctx context.Context, s Sidecar) {
+	fields := strings.Fields(s.Command)
+	dir := w.c.Dir
+	if s.Dir != "" {
+		dir = s.Dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(w.c.Dir, dir)
+		}
+	}
+	prefix := color.BlueString("[%s] ", s.Name)
+	stdout, stderr := prefixLines(w.c.Stdout, prefix), prefixLines(w.c.Stderr, prefix)
+	backoff := sidecarMinBackoff
+	for {
+		started := w.c.Clock.Now()
+		proc, err := w.c.Runner.Start(ctx, Cmd{
+			Name:   fields[0],
+			Args:   fields[1:],
+			Dir:    dir,
+			Env:    append(w.runEnv(), s.Env...),
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+		if err == nil {
+			checkCtx, stopCheck := context.WithCancel(ctx)
+			go w.sidecarReady(checkCtx, s, dir)
+			err = describeExit(proc.Wait())
+			stopCheck()
+		}
+		stdout.Flush()
+		stderr.Flush()
+		if ctx.Err() != nil {
+			return
+		}
+		if w.c.Clock.Now().Sub(started) > sidecarStableAfter {
+			backoff = sidecarMinBackoff
+		}
+		w.c.Logf(color.YellowString("sidecar %s exited: %v, restarting in %v", s.Name, err, backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.c.Clock.After(backoff):
+		}
+		backoff = min(2*backoff, sidecarMaxBackoff)
+	}
+}
+
+// sidecarReady runs the HealthCheck of s, started in dir, until it succeeds
+// or ctx is done, and then reports s as ready to the targets depending on
+// it.
+func (w *watcher) sidecarReady(ctx context.Context, s Sidecar, dir string) {
+	fields := strings.Fields(s.HealthCheck)
+	if len(fields) == 0 {
+		w.opts.deps.done(s.Name)
+		return
+	}
+	for {
+		err := w.c.Runner.Run(ctx, Cmd{
+			Name:   fields[0],
+			Args:   fields[1:],
+			Dir:    dir,
+			Env:    append(w.runEnv(), s.Env...),
+			Stdout: io.Discard,
+			Stderr: io.Discard,
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			w.c.Logf(color.BlueString("sidecar %s is healthy", s.Name))
+			w.opts.deps.done(s.Name)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.c.Clock.After(sidecarHealthEvery):
+		}
+	}
+}
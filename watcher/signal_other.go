@@ -0,0 +1,29 @@
+//go:build !unix
+
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseSignal returns the signal named name, such as "SIGTERM" or "hup".
+// Only interrupts and kills can be sent on this platform, so the other Unix
+// signals that stop or notify a program fall back to an interrupt, which
+// sets fallback.
+func parseSignal(name string) (sig os.Signal, fallback bool, err error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	switch name {
+	case "SIGINT":
+		return os.Interrupt, false, nil
+	case "SIGKILL":
+		return os.Kill, false, nil
+	case "SIGTERM", "SIGHUP", "SIGQUIT", "SIGUSR1", "SIGUSR2":
+		return os.Interrupt, true, nil
+	}
+	return nil, false, fmt.Errorf("unknown signal %q", name)
+}
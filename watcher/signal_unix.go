@@ -0,0 +1,26 @@
+//go:build unix
+
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// parseSignal returns the signal named name, such as "SIGTERM" or "hup".
+// The fallback result is set when the platform lacks the signal and another
+// one is used instead, which never happens on Unix.
+func parseSignal(name string) (sig os.Signal, fallback bool, err error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	s := unix.SignalNum(name)
+	if s == 0 {
+		return nil, false, fmt.Errorf("unknown signal %q", name)
+	}
+	return s, false, nil
+}
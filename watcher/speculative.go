@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+)
+
+// speculativeBuild is a build started on the first change of a burst, while
+// Debounce waits for the others, see Config.SpeculativeBuild.
+type speculativeBuild struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	err    error
+}
+
+// speculative reports whether the changes are built before the debounce
+// fires: only go build writes the program to a path of gowatch, and only
+// when nothing that start runs before the build, the PreBuild commands, the
+// AutoTidy and the check of a pinned toolchain, can change what it builds.
+func (w *watcher) speculative() bool {
+	return w.c.SpeculativeBuild && w.c.Debounce > 0 && w.c.Build == "" && w.c.Mode != ModeTest && w.c.Exec == "" &&
+		len(w.c.PreBuild) == 0 && !w.c.AutoTidy && w.c.GoBinary == "" && w.c.GoVersion == ""
+}
+
+// speculativePath is where the speculative build writes the program.
+func (w *watcher) speculativePath() string {
+	return w.binpath + ".spec"
+}
+
+// startSpeculative starts building the current files in the background,
+// replacing the speculative build in progress.
+func (w *watcher) startSpeculative(ctx context.Context) {
+	w.cancelSpeculative()
+	ctx, cancel := context.WithCancel(ctx)
+	s := &speculativeBuild{cancel: cancel, done: make(chan struct{})}
+	w.spec = s
+	name, args := w.buildCommand(w.speculativePath())
+	go func() {
+		defer close(s.done)
+		s.err = w.c.Runner.Run(ctx, Cmd{
+			Name:   name,
+			Args:   args,
+			Dir:    w.c.Dir,
+			Env:    append(os.Environ(), w.c.BuildEnv...),
+			Stdout: &s.stdout,
+			Stderr: &s.stderr,
+		})
+	}()
+}
+
+// cancelSpeculative stops the speculative build, whose files changed since
+// it started, if any.
+func (w *watcher) cancelSpeculative() {
+	if w.spec == nil {
+		return
+	}
+	w.spec.cancel()
+	<-w.spec.done
+	w.spec = nil
+	os.Remove(w.speculativePath())
+}
+
+// takeSpeculative waits for the speculative build, if any, and makes it the
+// build of the program: its output is written to stdout and stderr, and the
+// program it built moved to the staging path. It reports false if there is
+// no speculative build to take.
+func (w *watcher) takeSpeculative(stdout, stderr io.Writer) (bool, error) {
+	s := w.spec
+	if s == nil {
+		return false, nil
+	}
+	<-s.done
+	w.spec = nil
+	stdout.Write(s.stdout.Bytes())
+	stderr.Write(s.stderr.Bytes())
+	if s.err != nil {
+		return true, s.err
+	}
+	if err := os.Rename(w.speculativePath(), w.stagingPath()); err != nil {
+		// Build again.
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// stdinPump forwards Config.Stdin to the program across restarts. Each
+// process gets its own pipe, so that input is neither read by a process that
+// already exited nor lost between two runs: input read while no process is
+// running is held until the next one starts.
+type stdinPump struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	w    *os.File // write end of the pipe of the running process
+	eof  bool
+}
+
+func newStdinPump(r io.Reader) *stdinPump {
+	p := &stdinPump{}
+	p.cond = sync.NewCond(&p.mu)
+	go p.copy(r)
+	return p
+}
+
+func (p *stdinPump) copy(r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			p.mu.Lock()
+			for {
+				for p.w == nil {
+					p.cond.Wait()
+				}
+				if _, err := p.w.Write(buf[:n]); err == nil {
+					break
+				}
+				// The process exited, keep the input for the next one.
+				p.w.Close()
+				p.w = nil
+			}
+			p.mu.Unlock()
+		}
+		if err != nil {
+			p.mu.Lock()
+			p.eof = true
+			if p.w != nil {
+				p.w.Close()
+				p.w = nil
+			}
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+// attach returns the standard input of a new process. The caller closes it
+// once the process started.
+func (p *stdinPump) attach() (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.w != nil {
+		p.w.Close()
+	}
+	if p.eof {
+		w.Close()
+		return r, nil
+	}
+	p.w = w
+	p.cond.Broadcast()
+	return r, nil
+}
+
+// detach closes the standard input of the process, after it exited.
+func (p *stdinPump) detach() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.w != nil {
+		p.w.Close()
+		p.w = nil
+	}
+}
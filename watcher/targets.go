@@ -0,0 +1,294 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Target is one of several programs watched by a single gowatch, such as
+// the API server and the worker of a monorepo. Every target builds and runs
+// its own main package and is only rebuilt and restarted when a file of its
+// own package graph changes.
+type Target struct {
+	Name string
+	// Dir is the directory the target is built and run in, relative to
+	// Config.Dir, so that the program finds the files it opens with
+	// relative paths, such as its config and static assets.
+	Dir string
+	// Package is the main package of the target, such as ./cmd/api,
+	// relative to Dir. It is the package in Dir by default.
+	Package string
+	// BuildFlags and Env are added to the BuildFlags and RunEnv of the
+	// config, and RuntimeArgs replace its RuntimeArgs if set.
+	BuildFlags  []string
+	RuntimeArgs []string
+	Env         []string
+	// DependsOn are the names of the targets and Sidecars the target waits
+	// for before it starts: a target until its program exits successfully
+	// for the first time, such as the migrations of a database, or until
+	// its ReadyCheck succeeds, and a sidecar until its HealthCheck
+	// succeeds.
+	DependsOn []string
+	// ReadyCheck is the Config.ReadyCheck of the target.
+	ReadyCheck string
+}
+
+// dependencies tracks the targets and sidecars that are ready for the
+// targets that depend on them. Its methods are no-ops on a nil
+// *dependencies, as a watcher without Targets has none.
+type dependencies struct {
+	mu    sync.Mutex
+	ready map[string]chan struct{}
+}
+
+func newDependencies(names []string) *dependencies {
+	d := &dependencies{ready: map[string]chan struct{}{}}
+	for _, name := range names {
+		d.ready[name] = make(chan struct{})
+	}
+	return d
+}
+
+// done reports that the target or sidecar name is ready.
+func (d *dependencies) done(name string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ch, ok := d.ready[name]
+	if !ok {
+		return
+	}
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// wait waits until the targets and sidecars of names are ready, or ctx is
+// done.
+func (d *dependencies) wait(ctx context.Context, names []string, logf func(string, ...any)) error {
+	if d == nil || len(names) == 0 {
+		return nil
+	}
+	logf("waiting for %s", strings.Join(names, ", "))
+	for _, name := range names {
+		select {
+		case <-d.ready[name]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// checkDependencies checks that the targets of c depend on targets and
+// sidecars that exist, without cycles, and that the sidecars can start.
+func checkDependencies(c Config) error {
+	if err := checkSidecars(c.Sidecars); err != nil {
+		return err
+	}
+	targets := map[string]Target{}
+	for _, t := range c.Targets {
+		targets[t.Name] = t
+	}
+	sidecars := map[string]bool{}
+	for _, s := range c.Sidecars {
+		sidecars[s.Name] = true
+	}
+	for _, t := range c.Targets {
+		for _, dep := range t.DependsOn {
+			if _, ok := targets[dep]; !ok && !sidecars[dep] {
+				return fmt.Errorf("target %q depends on %q, which is neither a target nor a sidecar", t.Name, dep)
+			}
+		}
+	}
+	// visiting holds the path of targets being visited, and visited the
+	// targets whose dependencies have no cycle.
+	var visiting []string
+	visited := map[string]bool{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		for i, v := range visiting {
+			if v == name {
+				return fmt.Errorf("dependency cycle: %s", strings.Join(append(visiting[i:], name), " -> "))
+			}
+		}
+		if visited[name] {
+			return nil
+		}
+		visiting = append(visiting, name)
+		for _, dep := range targets[name].DependsOn {
+			if _, ok := targets[dep]; ok {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		visiting = visiting[:len(visiting)-1]
+		visited[name] = true
+		return nil
+	}
+	for _, t := range c.Targets {
+		if err := visit(t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTargets runs a watcher for every target of c, tagging its output with
+// the name of the target in a color of its own, until ctx is done or one of
+// them fails. The Sidecars and the Proxy of c belong to the first target.
+func runTargets(ctx context.Context, c Config) error {
+	if c.Attach != "" {
+		return fmt.Errorf("a program cannot be attached to with several targets")
+	}
+	if c.ClearScreen {
+		return fmt.Errorf("the screen cannot be cleared for several targets, it would clear the output of the others")
+	}
+	if c.Dir == "" {
+		c.Dir = "."
+	}
+	names := map[string]bool{}
+	width := 0
+	for i, t := range c.Targets {
+		if t.Name == "" {
+			return fmt.Errorf("target %d has no name", i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target %q", t.Name)
+		}
+		names[t.Name] = true
+		width = max(width, len(t.Name))
+	}
+	if err := checkDependencies(c); err != nil {
+		return err
+	}
+	var deps []string
+	for _, t := range c.Targets {
+		deps = append(deps, t.Name)
+	}
+	for _, s := range c.Sidecars {
+		deps = append(deps, s.Name)
+	}
+	opts := runOptions{deps: newDependencies(deps)}
+	// The targets share a single control API.
+	if c.ControlAddr != "" {
+		logf := c.Logf
+		if logf == nil {
+			logf = log.Printf
+		}
+		srv, err := newControlServer(c.ControlAddr, logf)
+		if err != nil {
+			return fmt.Errorf("control: %w", err)
+		}
+		defer srv.close()
+		opts.controlServer = srv
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The targets share the dashboard, a tab each, and the input of the
+	// terminal that quits with RestartOnInterrupt.
+	if c.TUI {
+		if c.Clock == nil {
+			c.Clock = systemClock{}
+		}
+		screen, err := newTUIScreen(c.Clock, cancel, c.Keys)
+		if err != nil {
+			return err
+		}
+		defer screen.close()
+		opts.tuiScreen = screen
+	} else if c.RestartOnInterrupt && c.Stdin == nil {
+		opts.quit = quitInput(os.Stdin)
+	}
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i, t := range c.Targets {
+		tc, topts, flush := c.target(t, i, width, opts)
+		wg.Add(1)
+		go func(name string, tc Config, topts runOptions) {
+			defer wg.Done()
+			defer flush()
+			if err := runWatcher(ctx, tc, topts); err != nil {
+				once.Do(func() { firstErr = fmt.Errorf("%s: %w", name, err) })
+			}
+			// A target stops on its own only with Once or when it fails,
+			// which ends the run of every target.
+			cancel()
+		}(t.Name, tc, topts)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// target returns the config and the options, from the shared opts, of the
+// i-th target t of c, whose output is tagged with a tag padded to width,
+// and the function flushing its output.
+func (c Config) target(t Target, i, width int, opts runOptions) (Config, runOptions, func()) {
+	tc := c
+	tc.Targets = nil
+	tc.Name = t.Name
+	tc.Dir = t.Dir
+	if !filepath.IsAbs(tc.Dir) {
+		tc.Dir = filepath.Join(c.Dir, t.Dir)
+	}
+	tc.BuildFlags = append(append([]string(nil), c.BuildFlags...), t.BuildFlags...)
+	tc.RunEnv = append(append([]string(nil), c.RunEnv...), t.Env...)
+	if len(t.RuntimeArgs) > 0 {
+		tc.RuntimeArgs = t.RuntimeArgs
+	}
+	if t.Package != "" {
+		tc.Package = t.Package
+	}
+	tc.ReadyCheck = t.ReadyCheck
+	opts.dependsOn = t.DependsOn
+	opts.tagged = true
+	// The dependents of the target wait for its first successful exit.
+	onExit := c.OnProcessExit
+	tc.OnProcessExit = func(err error) {
+		if err == nil {
+			opts.deps.done(t.Name)
+		}
+		if onExit != nil {
+			onExit(err)
+		}
+	}
+	// The input of the terminal cannot be shared between the programs.
+	tc.Stdin = nil
+	if i > 0 {
+		tc.Sidecars, tc.Proxy, tc.ProxyTarget = nil, "", ""
+	}
+	tc.Record = targetPath(c.Record, t.Name)
+	tc.History = targetPath(c.History, t.Name)
+	tc.SummaryOut = targetPath(c.SummaryOut, t.Name)
+	if c.TUI {
+		// The tab of the target shows its name.
+		return tc, opts, func() {}
+	}
+	flush := tc.tagOutput(tag(t.Name, i, width))
+	return tc, opts, flush
+}
+
+// targetPath returns the file of the target named name for a file of the
+// config, such as the History, by adding the name before its extension:
+// history.api.jsonl for history.jsonl.
+func targetPath(path, name string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
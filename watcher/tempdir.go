@@ -0,0 +1,50 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pidFile is written in every temporary directory with the process id of
+// the gowatch that owns it.
+const pidFile = "gowatch.pid"
+
+// makeTempDir creates the temporary directory the program is built in.
+func makeTempDir() (string, error) {
+	dir, err := os.MkdirTemp("", "gowatch")
+	if err != nil {
+		return "", fmt.Errorf("os.MkdirTemp: %w", err)
+	}
+	pid := strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(filepath.Join(dir, pidFile), []byte(pid), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return dir, nil
+}
+
+// removeStaleTempDirs removes the temporary directories left behind by
+// gowatch processes that were killed before cleaning up. Directories without
+// a pid file, or whose process is still running, are kept.
+func removeStaleTempDirs(logf func(string, ...any)) {
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), "gowatch*"))
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		b, err := os.ReadFile(filepath.Join(dir, pidFile))
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			logf("error removing stale temporary directory: %v", err)
+		}
+	}
+}
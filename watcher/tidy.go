@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// tidy runs "go mod tidy" before a build if the file that triggered it
+// imports packages that no module of go.mod provides.
+func (w *watcher) tidy(ctx context.Context) {
+	if !strings.HasSuffix(w.trigger, ".go") {
+		return
+	}
+	gomod, err := findGoMod(w.c.Dir)
+	if err != nil {
+		w.c.Logf("auto tidy: %v", err)
+		return
+	}
+	missing, err := missingImports(w.trigger, gomod)
+	if err != nil {
+		w.c.Logf("auto tidy: %v", err)
+		return
+	}
+	if len(missing) == 0 {
+		return
+	}
+	w.c.Logf("no module provides %s, running go mod tidy", strings.Join(missing, ", "))
+	err = w.c.Runner.Run(ctx, Cmd{
+		Name:   w.goCommand(),
+		Args:   []string{"mod", "tidy"},
+		Dir:    filepath.Dir(gomod),
+		Env:    append(os.Environ(), w.c.BuildEnv...),
+		Stdout: w.c.Stdout,
+		Stderr: w.c.Stderr,
+	})
+	if err != nil {
+		w.c.Logf("go mod tidy: %v", err)
+	}
+}
+
+// findGoMod returns the path of the go.mod file of the module dir is in.
+func findGoMod(dir string) (string, error) {
+	for {
+		path := filepath.Join(dir, "go.mod")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("go.mod not found")
+		}
+		dir = parent
+	}
+}
+
+// missingImports returns the imports of the Go file at path that are not
+// in the standard library, in the main module or in a module required or
+// replaced by the go.mod file at gomod.
+func missingImports(path, gomod string) ([]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		// The import block is being edited, the build reports it.
+		return nil, nil
+	}
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return nil, err
+	}
+	mf, err := modfile.ParseLax(gomod, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("modfile.Parse: %w", err)
+	}
+	var provided []string
+	if mf.Module != nil {
+		provided = append(provided, mf.Module.Mod.Path)
+	}
+	for _, r := range mf.Require {
+		provided = append(provided, r.Mod.Path)
+	}
+	for _, r := range mf.Replace {
+		provided = append(provided, r.Old.Path)
+	}
+	var missing []string
+	for _, spec := range f.Imports {
+		imp, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || imp == "C" || isStd(imp) || isProvided(imp, provided) {
+			continue
+		}
+		missing = append(missing, imp)
+	}
+	return missing, nil
+}
+
+func isProvided(importPath string, modules []string) bool {
+	for _, mod := range modules {
+		if inModule(importPath, mod) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStd reports whether importPath looks like a standard library package,
+// whose first path element has no dot.
+func isStd(importPath string) bool {
+	elem, _, _ := strings.Cut(importPath, "/")
+	return !strings.Contains(elem, ".")
+}
+
+// resolveModules looks for the commands the go command suggests in output
+// to add missing modules or go.sum entries. With ResolveModules it runs them
+// and reports whether they all succeeded, otherwise it logs them.
+func (w *watcher) resolveModules(ctx context.Context, output string) bool {
+	cmds := suggestedModCommands(output)
+	if len(cmds) == 0 {
+		return false
+	}
+	if !w.c.ResolveModules {
+		w.c.Logf("the build needs missing modules, run %q or enable ResolveModules (--resolve-modules)", strings.Join(cmds, "; "))
+		return false
+	}
+	for _, cmd := range cmds {
+		w.c.Logf("running %s", cmd)
+		fields := strings.Fields(cmd)
+		err := w.c.Runner.Run(ctx, Cmd{
+			Name:   w.goCommand(),
+			Args:   fields[1:],
+			Dir:    w.c.Dir,
+			Env:    append(os.Environ(), w.c.BuildEnv...),
+			Stdout: w.c.Stdout,
+			Stderr: w.c.Stderr,
+		})
+		if err != nil {
+			w.c.Logf("%s: %v", cmd, err)
+			return false
+		}
+	}
+	return true
+}
+
+// suggestedModCommands returns the unique "go get" and "go mod download"
+// commands suggested by the errors in output, such as:
+//
+//	no required module provides package example.com/pkg; to add it:
+//		go get example.com/pkg
+func suggestedModCommands(output string) []string {
+	var cmds []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "go get ") && !strings.HasPrefix(line, "go mod download") {
+			continue
+		}
+		if !seen[line] {
+			seen[line] = true
+			cmds = append(cmds, line)
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,173 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pinToolchain resolves c.GoBinary and checks that it provides c.GoVersion.
+// The bin directory of its GOROOT, which has a "go" command even when
+// GoBinary is a wrapper such as go1.21.3, is put first in the PATH of the
+// build environment so that custom build commands use it too. go/packages
+// runs the "go" command of the PATH of gowatch instead, which GOTOOLCHAIN
+// makes switch to the pinned one, see toolchainShim.
+func pinToolchain(c *Config) error {
+	if c.GoBinary == "" && c.GoVersion == "" {
+		return nil
+	}
+	name := c.GoBinary
+	if name == "" {
+		name = "go"
+	}
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("go binary %q not found: %w", name, err)
+	}
+	bin, err = filepath.Abs(bin)
+	if err != nil {
+		return fmt.Errorf("filepath.Abs: %w", err)
+	}
+	c.GoBinary = bin
+	cmd := exec.Command(bin, "env", "GOVERSION", "GOROOT")
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), c.BuildEnv...)
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%s env GOVERSION GOROOT: %w", bin, err)
+	}
+	got, goroot, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if c.GoVersion != "" {
+		want := "go" + strings.TrimPrefix(c.GoVersion, "go")
+		if got != want && !strings.HasPrefix(got, want+".") {
+			return fmt.Errorf("%s is required but %s provides %s: install it, set GoBinary to a matching go command or allow downloading it with GOTOOLCHAIN=%s", want, bin, got, want)
+		}
+	}
+	goBin := filepath.Dir(bin)
+	if dir := filepath.Join(strings.TrimSpace(goroot), "bin"); goroot != "" {
+		if _, err := exec.LookPath(filepath.Join(dir, "go")); err == nil {
+			goBin = dir
+		}
+	}
+	path := goBin + string(os.PathListSeparator) + os.Getenv("PATH")
+	if shim := toolchainShim(goBin, got); shim != "" {
+		path = shim + string(os.PathListSeparator) + path
+		c.BuildEnv = append(c.BuildEnv, "GOTOOLCHAIN="+got)
+	}
+	c.BuildEnv = append(c.BuildEnv, "PATH="+path)
+	return nil
+}
+
+// switchableVersion matches the versions of the toolchains that a go command
+// can switch to with GOTOOLCHAIN, go1.21.0 and later releases.
+var switchableVersion = regexp.MustCompile(`^go1\.(2[1-9]|[3-9][0-9])\.[0-9]+$`)
+
+// toolchainShim returns a directory holding a link to the go command of
+// goBin named after its version, such as go1.21.3, or "" if it cannot be
+// made. The go command looks for the toolchain named by GOTOOLCHAIN in its
+// PATH, so that with the directory in the PATH of the build environment,
+// any go command run with it, such as the one go/packages finds in the PATH
+// of gowatch, switches to the pinned toolchain. The directory is kept in
+// the user cache directory, to be reused by the next runs.
+func toolchainShim(goBin, version string) string {
+	if !switchableVersion.MatchString(version) {
+		return ""
+	}
+	target, err := exec.LookPath(filepath.Join(goBin, "go"))
+	if err != nil {
+		return ""
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(target))
+	dir := filepath.Join(cache, "gowatch", "toolchain", hex.EncodeToString(sum[:8]))
+	link := filepath.Join(dir, version+filepath.Ext(target))
+	if dest, err := os.Readlink(link); err == nil && dest == target {
+		return dir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return ""
+	}
+	os.Remove(link)
+	// Creating symbolic links may need a privilege on Windows, where hard
+	// links work on the same volume.
+	if os.Symlink(target, link) != nil && os.Link(target, link) != nil {
+		// Another gowatch may have made it in the meantime.
+		if dest, err := os.Readlink(link); err != nil || dest != target {
+			return ""
+		}
+	}
+	return dir
+}
+
+// checkToolchain compares the Go toolchain with the one of the last build
+// and reports whether it changed. When it did, the binary built by the
+// previous toolchain is removed so that it is not run again, and the build
+// cache gets the new toolchain to rebuild everything with. go env only runs
+// again when the toolchainKey changes.
+func (w *watcher) checkToolchain(ctx context.Context) bool {
+	key := w.toolchainKey()
+	if w.toolchain != "" && key == w.toolchainFiles {
+		return false
+	}
+	var out bytes.Buffer
+	err := w.c.Runner.Run(ctx, Cmd{
+		Name:   w.goCommand(),
+		Args:   []string{"env", "GOVERSION", "GOROOT"},
+		Dir:    w.c.Dir,
+		Env:    append(os.Environ(), w.c.BuildEnv...),
+		Stdout: &out,
+		Stderr: &out,
+	})
+	if err != nil {
+		// The build reports the problem.
+		return false
+	}
+	version, goroot, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+	id := version + " " + strings.TrimSpace(goroot)
+	last := w.toolchain
+	w.toolchain, w.toolchainFiles = id, w.toolchainKey()
+	if last == "" || last == id {
+		return false
+	}
+	w.c.Logf("go toolchain changed from %s to %s, rebuilding", last, id)
+	if err := os.Remove(w.binpath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		w.c.Logf("error removing the stale binary: %v", err)
+	}
+	return true
+}
+
+// toolchainKey identifies the files the toolchain is selected from by their
+// size and modification time: the go command, the go command of the GOROOT of
+// the last build, which differs when go.mod switches toolchains, and go.mod.
+func (w *watcher) toolchainKey() string {
+	var files []string
+	if bin, err := exec.LookPath(w.goCommand()); err == nil {
+		files = append(files, bin)
+	}
+	if _, goroot, ok := strings.Cut(w.toolchain, " "); ok {
+		if bin, err := exec.LookPath(filepath.Join(goroot, "bin", "go")); err == nil {
+			files = append(files, bin)
+		}
+	}
+	if mod, err := findGoMod(w.c.Dir); err == nil {
+		files = append(files, mod)
+	}
+	var key strings.Builder
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			fmt.Fprintf(&key, "%s %d %d\n", f, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return key.String()
+}
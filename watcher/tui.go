@@ -0,0 +1,687 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// tuiCommand is sent to the watch loop to act on the running program.
+type tuiCommand int
+
+const (
+	cmdRestart tuiCommand = iota
+	cmdTogglePause
+	cmdQuit
+	cmdPause
+	cmdResume
+)
+
+type tuiStatus int
+
+const (
+	tuiStarting tuiStatus = iota
+	tuiBuilding
+	tuiBuildFailed
+	tuiRunning
+	tuiExited
+	tuiBuilt
+)
+
+const (
+	tuiMaxLines     = 10000
+	tuiRefreshEvery = 50 * time.Millisecond
+)
+
+// tuiScreen is the terminal of the dashboard. It shows one tab per target,
+// the Tab key switching between them, and reads the keys for the tab shown.
+type tuiScreen struct {
+	in      *os.File
+	out     *os.File
+	clock   Clock
+	quit    func()
+	keys    map[string]string // key of every action
+	actions map[byte]string   // action of every key
+	restore func()
+	done    chan struct{}
+	closed  sync.Once
+
+	mu     sync.Mutex
+	tabs   []*tui
+	active int  // index of the tab shown
+	dirty  bool // the tab shown changed
+}
+
+func newTUIScreen(clock Clock, quit func(), keys map[string]string) (*tuiScreen, error) {
+	bound, actions, err := keyBindings(keys)
+	if err != nil {
+		return nil, err
+	}
+	in, out := os.Stdin, os.Stdout
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return nil, errors.New("the dashboard requires an interactive terminal")
+	}
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("term.MakeRaw: %w", err)
+	}
+	// Switch to the alternate screen and hide the cursor.
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l")
+	s := &tuiScreen{
+		in:      in,
+		out:     out,
+		clock:   clock,
+		quit:    quit,
+		keys:    bound,
+		actions: actions,
+		done:    make(chan struct{}),
+	}
+	s.restore = func() {
+		io.WriteString(out, "\x1b[?25h\x1b[?1049l")
+		term.Restore(int(in.Fd()), state)
+	}
+	go s.readInput()
+	go s.refresh()
+	return s, nil
+}
+
+// add returns a new tab named name, sending the commands of its keys to
+// commands. The tabs are in the order they are added.
+func (s *tuiScreen) add(name string, commands chan<- tuiCommand) *tui {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := &tui{
+		screen:   s,
+		name:     name,
+		commands: commands,
+		mu:       &s.mu,
+		dirty:    true,
+		match:    -1,
+	}
+	s.tabs = append(s.tabs, t)
+	s.dirty = true
+	return t
+}
+
+// close restores the terminal.
+func (s *tuiScreen) close() {
+	if s == nil {
+		return
+	}
+	s.closed.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.restore()
+	})
+}
+
+// tui is a tab of the dashboard: a status header, the output of the program
+// and of the build, the errors of the last failed build, and the available
+// keys. All methods are no-ops on a nil *tui so that the watcher can call
+// them unconditionally.
+type tui struct {
+	screen   *tuiScreen
+	name     string
+	commands chan<- tuiCommand
+
+	mu          *sync.Mutex // the one of the screen
+	history     *history
+	showHistory bool
+	dirty       bool
+	status      tuiStatus
+	paused      bool
+	lastFile    string
+	usage       string
+	buildStart  time.Time
+	buildTime   time.Duration
+	exitErr     error
+	lines       []string
+	buildErrors []string
+	filter      *regexp.Regexp
+	search      *regexp.Regexp
+	match       int    // index in lines of the selected search match, or -1
+	prompt      string // label of the active prompt, empty if none
+	input       string
+	message     string // colored message shown in the footer until a key is pressed
+}
+
+// writer returns an io.Writer whose lines are appended to the log pane.
+func (t *tui) writer() io.Writer {
+	return newLineWriter(func(line string) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.appendLine(strings.TrimRight(line, "\r\n"))
+	})
+}
+
+func (t *tui) logf(format string, a ...any) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	msg := fmt.Sprintf(format, a...)
+	t.appendLine(color.New(color.Faint).Sprint(t.screen.clock.Now().Format("15:04:05 ")) + msg)
+}
+
+func (t *tui) appendLine(line string) {
+	t.lines = append(t.lines, line)
+	if n := len(t.lines) - tuiMaxLines; n > 0 {
+		t.lines = t.lines[n:]
+		if t.match >= 0 {
+			t.match = max(t.match-n, -1)
+		}
+	}
+	t.dirty = true
+}
+
+func (t *tui) buildStarted() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status, t.buildStart, t.dirty = tuiBuilding, t.screen.clock.Now(), true
+}
+
+func (t *tui) buildFinished(output string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buildTime = t.screen.clock.Now().Sub(t.buildStart)
+	t.status, t.buildErrors, t.dirty = tuiBuilt, nil, true
+	if err != nil {
+		t.status = tuiBuildFailed
+		t.buildErrors = strings.Split(strings.TrimRight(output, "\n"), "\n")
+	}
+}
+
+func (t *tui) processStarted() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status, t.exitErr, t.dirty = tuiRunning, nil, true
+}
+
+func (t *tui) processExited(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status == tuiRunning {
+		t.status, t.exitErr, t.dirty = tuiExited, err, true
+	}
+}
+
+func (t *tui) setPaused(paused bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused, t.dirty = paused, true
+}
+
+func (t *tui) fileChanged(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastFile, t.dirty = name, true
+}
+
+func (t *tui) setHistory(h *history) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.history = h
+}
+
+func (t *tui) setUsage(usage string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usage, t.dirty = usage, true
+}
+
+func (t *tui) send(cmd tuiCommand) {
+	select {
+	case t.commands <- cmd:
+	case <-t.screen.done:
+	}
+}
+
+func (s *tuiScreen) readInput() {
+	buf := make([]byte, 64)
+	for {
+		n, err := s.in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			s.key(b)
+		}
+	}
+}
+
+// key switches to the next tab on Tab, unless a prompt is open, and passes
+// the other keys to the tab shown.
+func (s *tuiScreen) key(b byte) {
+	s.mu.Lock()
+	if len(s.tabs) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	t := s.tabs[s.active]
+	if b == '\t' && t.prompt == "" {
+		s.active = (s.active + 1) % len(s.tabs)
+		s.dirty = true
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	t.key(b)
+}
+
+func (t *tui) key(b byte) {
+	t.mu.Lock()
+	t.dirty = true
+	if t.prompt != "" {
+		defer t.mu.Unlock()
+		switch b {
+		case '\r', '\n':
+			if t.prompt == "filter" {
+				t.applyFilter(t.input)
+			} else {
+				t.applySearch(t.input)
+			}
+			t.prompt = ""
+		case 27: // escape
+			t.prompt = ""
+		case 127, 8: // backspace
+			if len(t.input) > 0 {
+				_, size := utf8.DecodeLastRuneInString(t.input)
+				t.input = t.input[:len(t.input)-size]
+			}
+		default:
+			if b >= 32 {
+				t.input += string(b)
+			}
+		}
+		return
+	}
+	t.message = ""
+	t.mu.Unlock()
+	switch b {
+	case 27: // escape
+		t.mu.Lock()
+		t.search, t.match = nil, -1
+		t.mu.Unlock()
+		return
+	case 3: // Ctrl-C, which raw mode delivers as input
+		t.screen.quit()
+		return
+	}
+	switch t.screen.actions[b] {
+	case KeyRestart:
+		t.send(cmdRestart)
+	case KeyPause:
+		t.send(cmdTogglePause)
+	case KeyClear:
+		t.mu.Lock()
+		t.lines, t.match = nil, -1
+		t.mu.Unlock()
+	case KeyFilter:
+		t.mu.Lock()
+		t.prompt, t.input = "filter", ""
+		if t.filter != nil {
+			t.input = t.filter.String()
+		}
+		t.mu.Unlock()
+	case KeySearch:
+		t.mu.Lock()
+		t.prompt, t.input = "/", ""
+		t.mu.Unlock()
+	case KeyOlder:
+		t.mu.Lock()
+		t.findMatch(-1)
+		t.mu.Unlock()
+	case KeyNewer:
+		t.mu.Lock()
+		t.findMatch(1)
+		t.mu.Unlock()
+	case KeyWrite:
+		t.mu.Lock()
+		t.dump()
+		t.mu.Unlock()
+	case KeyHistory:
+		t.mu.Lock()
+		t.showHistory = !t.showHistory
+		t.mu.Unlock()
+	case KeyQuit:
+		t.screen.quit()
+	}
+}
+
+func (t *tui) applyFilter(expr string) {
+	if expr == "" {
+		t.filter = nil
+		return
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		t.message = color.RedString("invalid filter: %v", err)
+		return
+	}
+	t.filter = re
+}
+
+// applySearch starts a search for expr from the end of the buffer.
+func (t *tui) applySearch(expr string) {
+	t.search, t.match = nil, -1
+	if expr == "" {
+		return
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		t.message = color.RedString("invalid search: %v", err)
+		return
+	}
+	t.search, t.match = re, len(t.lines)
+	t.findMatch(-1)
+}
+
+// findMatch selects the next visible line matching the search, looking at
+// older lines when dir is negative and newer lines when it is positive.
+// The selection stays in place if there is no further match.
+func (t *tui) findMatch(dir int) {
+	if t.search == nil {
+		return
+	}
+	start := t.match
+	if start < 0 {
+		start = len(t.lines)
+	}
+	for i := start + dir; i >= 0 && i < len(t.lines); i += dir {
+		if t.visible(i) && t.search.MatchString(stripANSI(t.lines[i])) {
+			t.match = i
+			return
+		}
+	}
+	if t.match < 0 || t.match >= len(t.lines) {
+		t.search, t.match = nil, -1
+	}
+	t.message = color.RedString("pattern not found")
+}
+
+// dump writes the buffered output, without colors, to a file in the
+// working directory.
+func (t *tui) dump() {
+	name := t.screen.clock.Now().Format("gowatch-20060102-150405.log")
+	var b strings.Builder
+	for _, line := range t.lines {
+		b.WriteString(stripANSI(line))
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(name, []byte(b.String()), 0o644); err != nil {
+		t.message = color.RedString("dump: %v", err)
+		return
+	}
+	t.message = fmt.Sprintf("wrote %d lines to %s", len(t.lines), name)
+}
+
+func (s *tuiScreen) refresh() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.clock.After(tuiRefreshEvery):
+		}
+		s.mu.Lock()
+		if len(s.tabs) == 0 {
+			s.mu.Unlock()
+			continue
+		}
+		if t := s.tabs[s.active]; t.dirty || s.dirty {
+			t.render()
+			t.dirty, s.dirty = false, false
+		}
+		s.mu.Unlock()
+	}
+}
+
+// tabBar names the tabs, the one shown in reverse video and the others
+// colored by their status.
+func (s *tuiScreen) tabBar() string {
+	var b strings.Builder
+	for i, t := range s.tabs {
+		name := " " + t.name + " "
+		switch {
+		case i == s.active:
+			name = color.New(color.ReverseVideo, color.Bold).Sprint(name)
+		case t.status == tuiBuildFailed || t.status == tuiExited && t.exitErr != nil:
+			name = color.RedString("%s", name)
+		case t.status == tuiBuilding:
+			name = color.YellowString("%s", name)
+		default:
+			name = color.New(color.Faint).Sprint(name)
+		}
+		b.WriteString(name)
+	}
+	return b.String()
+}
+
+// render draws the whole screen. It must be called with t.mu held.
+func (t *tui) render() {
+	width, height, err := term.GetSize(int(t.screen.out.Fd()))
+	if err != nil || width < 10 || height < 5 {
+		return
+	}
+	var rows []string
+	if len(t.screen.tabs) > 1 {
+		rows = append(rows, t.screen.tabBar())
+	}
+	rows = append(rows, t.header(), rule(width))
+
+	var errRows []string
+	if len(t.buildErrors) > 0 {
+		max := height / 3
+		errRows = append(errRows, rule(width))
+		for i, line := range t.buildErrors {
+			if i == max {
+				errRows = append(errRows, color.New(color.Faint).Sprintf("... %d more lines", len(t.buildErrors)-max))
+				break
+			}
+			errRows = append(errRows, color.RedString("%s", line))
+		}
+	}
+
+	logHeight := height - len(rows) - len(errRows) - 1
+	if t.showHistory {
+		rows = append(rows, t.historyLines(logHeight)...)
+	} else {
+		rows = append(rows, t.visibleLines(logHeight)...)
+	}
+	for len(rows) < height-len(errRows)-1 {
+		rows = append(rows, "")
+	}
+	rows = append(rows, errRows...)
+	rows = append(rows, t.footer())
+
+	var b strings.Builder
+	for i, row := range rows {
+		fmt.Fprintf(&b, "\x1b[%d;1H%s\x1b[0m\x1b[K", i+1, truncate(row, width))
+	}
+	io.WriteString(t.screen.out, b.String())
+}
+
+func (t *tui) header() string {
+	var status string
+	switch t.status {
+	case tuiStarting:
+		status = color.New(color.Faint).Sprint("starting")
+	case tuiBuilding:
+		status = color.YellowString("building...")
+	case tuiBuildFailed:
+		status = color.New(color.FgRed, color.Bold).Sprintf("build failed (%v)", t.buildTime.Round(time.Millisecond))
+	case tuiRunning:
+		status = color.GreenString("running (built in %v)", t.buildTime.Round(time.Millisecond))
+	case tuiExited:
+		status = color.RedString("exited: %v", t.exitErr)
+		if t.exitErr == nil {
+			status = color.New(color.Faint).Sprint("exited")
+		}
+	case tuiBuilt:
+		status = color.GreenString("built in %v", t.buildTime.Round(time.Millisecond))
+	}
+	header := color.New(color.Bold).Sprint(" gowatch ") + " " + status
+	if t.paused {
+		header += "  " + color.New(color.FgYellow, color.Bold).Sprint("PAUSED")
+	}
+	if t.filter != nil {
+		header += "  " + color.CyanString("filter: %s", t.filter)
+	}
+	if t.usage != "" {
+		header += "  " + color.New(color.Faint).Sprint(t.usage)
+	}
+	if t.lastFile != "" {
+		header += "  " + color.New(color.Faint).Sprintf("last change: %s", t.lastFile)
+	}
+	return header
+}
+
+func (t *tui) footer() string {
+	switch {
+	case t.prompt == "/":
+		return "/" + t.input + "\x1b[7m \x1b[0m"
+	case t.prompt != "":
+		return t.prompt + ": " + t.input + "\x1b[7m \x1b[0m"
+	case t.message != "":
+		return t.message
+	case t.search != nil:
+		return color.New(color.Faint).Sprint(keyHelp(t.screen.keys, KeyOlder, KeyNewer) + "  esc end search " + keyHelp(t.screen.keys, KeyWrite, KeyQuit))
+	}
+	help := keyHelp(t.screen.keys, KeyRestart, KeyPause, KeyFilter, KeySearch, KeyClear, KeyHistory, KeyWrite, KeyQuit)
+	if len(t.screen.tabs) > 1 {
+		help += "  tab next target"
+	}
+	return color.New(color.Faint).Sprint(help)
+}
+
+// historyLines returns the last n cycles of the history, newest first.
+func (t *tui) historyLines(n int) []string {
+	lines := []string{color.New(color.Bold).Sprintf("history (%s to go back to the log)", t.screen.keys[KeyHistory])}
+	cycles := t.history.snapshot()
+	for i := len(cycles) - 1; i >= 0 && len(lines) < n; i-- {
+		c := cycles[i]
+		line := c.String()
+		switch c.Result {
+		case ResultBuildFailed, ResultCrashed:
+			line = color.RedString("%s", line)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// visible reports whether the line at index i passes the filter.
+func (t *tui) visible(i int) bool {
+	return t.filter == nil || t.filter.MatchString(stripANSI(t.lines[i]))
+}
+
+// visibleLines returns n log lines that match the filter. They are the
+// last ones, unless a search match is selected, in which case they are
+// the ones around it.
+func (t *tui) visibleLines(n int) []string {
+	end := len(t.lines)
+	if t.match >= 0 && t.match < len(t.lines) {
+		end = t.match + 1
+		for after := 0; end < len(t.lines) && after < n/2; end++ {
+			if t.visible(end) {
+				after++
+			}
+		}
+	}
+	var visible []string
+	for i := end - 1; i >= 0 && len(visible) < n; i-- {
+		if !t.visible(i) {
+			continue
+		}
+		visible = append(visible, t.highlight(i))
+	}
+	for i, j := 0, len(visible)-1; i < j; i, j = i+1, j-1 {
+		visible[i], visible[j] = visible[j], visible[i]
+	}
+	return visible
+}
+
+// highlight returns the line at index i with the search matches shown in
+// reverse video, and the selected match marked in the margin.
+func (t *tui) highlight(i int) string {
+	line := t.lines[i]
+	if t.search == nil || !t.search.MatchString(stripANSI(line)) {
+		return line
+	}
+	line = t.search.ReplaceAllStringFunc(stripANSI(line), func(m string) string {
+		return "\x1b[7m" + m + "\x1b[27m"
+	})
+	if i == t.match {
+		return color.New(color.FgYellow, color.Bold).Sprint("> ") + line
+	}
+	return line
+}
+
+func rule(width int) string {
+	return color.New(color.Faint).Sprint(strings.Repeat("─", width))
+}
+
+var ansiRE = regexp.MustCompile("\x1b\\[[0-9;?]*[a-zA-Z]")
+
+func stripANSI(s string) string {
+	return ansiRE.ReplaceAllString(s, "")
+}
+
+// truncate cuts s to width visible characters, keeping ANSI escape
+// sequences intact.
+func truncate(s string, width int) string {
+	s = strings.ReplaceAll(s, "\t", "    ")
+	var b strings.Builder
+	visible := 0
+	for i := 0; i < len(s); {
+		if loc := ansiRE.FindStringIndex(s[i:]); loc != nil && loc[0] == 0 {
+			b.WriteString(s[i : i+loc[1]])
+			i += loc[1]
+			continue
+		}
+		if visible == width {
+			break
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r >= 32 {
+			b.WriteRune(r)
+			visible++
+		}
+		i += size
+	}
+	return b.String()
+}
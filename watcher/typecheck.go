@@ -0,0 +1,127 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// errTypeCheck is returned by typeCheck when the package of the changed file
+// does not compile.
+var errTypeCheck = errors.New("type check failed")
+
+// typeCheck type checks the package of the file that triggered the build
+// from source, importing its dependencies from their export data, and
+// writes its errors to out in the format of go build. Only the package is
+// parsed and checked: the export data of its dependencies comes from the
+// build cache, where the previous builds left it. Anything that prevents
+// the check, such as a dependency that does not compile, an import of "C" or
+// export data the checker cannot read, is left to the build.
+func (w *watcher) typeCheck(ctx context.Context, out io.Writer) error {
+	if !strings.HasSuffix(w.trigger, ".go") {
+		return nil
+	}
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports |
+			packages.NeedDeps | packages.NeedExportFile,
+		Dir:        w.c.Dir,
+		Env:        append(os.Environ(), w.c.BuildEnv...),
+		BuildFlags: w.c.BuildFlags,
+	}
+	pkgs, err := packages.Load(cfg, "file="+w.trigger)
+	if err != nil || len(pkgs) != 1 || len(pkgs[0].GoFiles) == 0 {
+		return nil
+	}
+	pkg := pkgs[0]
+	// The export data of the imports of the package, by the path they are
+	// imported with, and of their own dependencies, by package path.
+	exports := map[string]string{}
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		exports[p.PkgPath] = p.ExportFile
+	})
+	for path, p := range pkg.Imports {
+		exports[path] = p.ExportFile
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	var errs []string
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				errs = append(errs, fmt.Sprintf("%s: %s", w.relativePos(e.Pos), e.Msg))
+			}
+		} else if err != nil {
+			return nil
+		}
+		for _, imp := range f.Imports {
+			if imp.Path.Value == `"C"` {
+				return nil
+			}
+		}
+		files = append(files, f)
+	}
+	if len(errs) == 0 {
+		gc := importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+			if exports[path] == "" {
+				return nil, fmt.Errorf("no export data for %s", path)
+			}
+			return os.Open(exports[path])
+		})
+		importFailed := false
+		conf := types.Config{
+			Importer: importerFunc(func(path string) (*types.Package, error) {
+				p, err := gc.Import(path)
+				if err != nil {
+					importFailed = true
+				}
+				return p, err
+			}),
+			Error: func(err error) {
+				if e, ok := err.(types.Error); ok && !e.Soft {
+					errs = append(errs, fmt.Sprintf("%s: %s", w.relativePos(e.Fset.Position(e.Pos)), e.Msg))
+				}
+			},
+		}
+		conf.Check(pkg.PkgPath, fset, files, nil)
+		if importFailed {
+			return nil
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, e := range errs {
+		fmt.Fprintln(out, e)
+	}
+	return errTypeCheck
+}
+
+// importerFunc is a types.Importer calling a function.
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// relativePos makes the file of a position relative to Dir, as go build
+// prints it.
+func (w *watcher) relativePos(pos token.Position) string {
+	if rel, err := filepath.Rel(w.c.Dir, pos.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+		pos.Filename = "./" + filepath.ToSlash(rel)
+	}
+	return pos.String()
+}
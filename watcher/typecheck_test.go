@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// maxTypeCheck bounds a type check of a package importing net/http, once
+// the build cache holds its dependencies as after a first build.
+const maxTypeCheck = time.Second
+
+func TestTypeCheckCost(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+	const src = `package main
+
+import "net/http"
+
+func main() {
+	var s string = http.StatusOK
+	http.ListenAndServe(s, nil)
+}
+`
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":  "module example.com/tc\n\ngo 1.21\n",
+		"main.go": src,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := newWatcher(Config{Dir: dir, TypeCheck: true, Logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.trigger = filepath.Join(dir, "main.go")
+	check := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := w.typeCheck(context.Background(), &out); !errors.Is(err, errTypeCheck) {
+			t.Fatalf("typeCheck = %v, want %v", err, errTypeCheck)
+		}
+		return out.String()
+	}
+	// The first check fills the build cache, as the first build would.
+	check()
+	started := time.Now()
+	out := check()
+	if d := time.Since(started); d > maxTypeCheck {
+		t.Errorf("type check took %v, want at most %v", d, maxTypeCheck)
+	}
+	if want := "./main.go:6:17: cannot use http.StatusOK"; !strings.HasPrefix(out, want) {
+		t.Errorf("type check output = %q, want it to start with %q", out, want)
+	}
+
+	fixed := strings.Replace(src, "var s string = http.StatusOK", "s := http.StatusText(http.StatusOK)", 1)
+	if err := os.WriteFile(w.trigger, []byte(fixed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := w.typeCheck(context.Background(), &buf); err != nil {
+		t.Errorf("typeCheck of a compiling package = %v, output %q", err, buf.String())
+	}
+}
@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// watchCounter is implemented by the backends that use kernel watches.
+type watchCounter interface {
+	watchCount() int
+}
+
+func (b *fsnotifyBackend) watchCount() int { return len(b.w.WatchList()) }
+
+// usage describes the resources used to watch the files.
+type usage struct {
+	files, dirs int
+	watches     int // kernel watches used, -1 if unknown
+	limit       int // kernel watches allowed per user, 0 if unknown
+}
+
+func (w *watcher) usage(b Backend) usage {
+	dirs := map[string]bool{}
+	for key := range w.files {
+		dirs[filepath.Dir(key)] = true
+	}
+	u := usage{files: len(w.files), dirs: len(dirs), watches: -1}
+	if c, ok := b.(watchCounter); ok {
+		u.watches, u.limit = c.watchCount(), inotifyLimit()
+	}
+	return u
+}
+
+func (u usage) String() string {
+	s := fmt.Sprintf("%d files in %d directories", u.files, u.dirs)
+	switch {
+	case u.watches >= 0 && u.limit > 0:
+		s += fmt.Sprintf(", %d of %d inotify watches", u.watches, u.limit)
+	case u.watches >= 0:
+		s += fmt.Sprintf(", %d watches", u.watches)
+	}
+	return s
+}
+
+// nearLimit reports whether 90% of the kernel watches allowed are used.
+func (u usage) nearLimit() bool {
+	return u.limit > 0 && u.watches*10 >= u.limit*9
+}
+
+// inotifyLimit returns the number of inotify watches a user may create, or 0
+// on platforms without inotify.
+func inotifyLimit() int {
+	data, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return n
+}
+
+// ResourceUsage is the memory and CPU time used by the program and every
+// process it spawned, read from its cgroup with Cgroup.
+type ResourceUsage struct {
+	// Memory is the memory in use, in bytes, and PeakMemory the most the
+	// program used, which is 0 before Linux 5.19.
+	Memory     uint64 `json:"memory"`
+	PeakMemory uint64 `json:"peakMemory,omitempty"`
+	// CPU is the CPU time used, in user and system mode.
+	CPU time.Duration `json:"cpu"`
+}
+
+func (u ResourceUsage) String() string {
+	s := formatBytes(u.Memory) + " of memory"
+	if u.PeakMemory > 0 {
+		s += fmt.Sprintf(" (peak %s)", formatBytes(u.PeakMemory))
+	}
+	return s + fmt.Sprintf(", %v of CPU", u.CPU.Round(time.Millisecond))
+}
+
+// formatBytes formats n bytes in the largest binary unit below it.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// resourceUsage returns the resources used by p, if known. A Process of a
+// custom Runner can report them with a ResourceUsage method.
+func resourceUsage(p Process) (ResourceUsage, bool) {
+	if p, ok := p.(interface{ ResourceUsage() (ResourceUsage, bool) }); ok {
+		return p.ResourceUsage()
+	}
+	return ResourceUsage{}, false
+}
+
+// reportUsage logs the watch usage along with the rate of the events
+// received over the last period and the resources used by the program, and
+// warns when the kernel watches are about to run out.
+func (w *watcher) reportUsage(b Backend, events int, period time.Duration) {
+	u := w.usage(b)
+	status := u.String()
+	if ru, ok := resourceUsage(w.proc); ok {
+		status += ", program: " + ru.String()
+	}
+	w.tui.setUsage(status)
+	if period > 0 {
+		w.c.Logf("watching %v, %.1f events/s", u, float64(events)/period.Seconds())
+		if ru, ok := resourceUsage(w.proc); ok {
+			w.c.Logf("the program uses %v", ru)
+		}
+	}
+	if u.nearLimit() {
+		w.c.Logf(color.YellowString("%d of the %d inotify watches allowed are in use, raise fs.inotify.max_user_watches or use the poll or watchman backend", u.watches, u.limit))
+	}
+}
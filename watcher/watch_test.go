@@ -0,0 +1,253 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves with advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// advance moves the time forward by d, firing the timers that expire.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = timers
+}
+
+// fakeRunner is a Runner recording the commands of a watcher. Its builds
+// write an empty program, and its programs run until they are signaled.
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands []string
+	changed  chan struct{} // receives a value for every command
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{changed: make(chan struct{}, 100)}
+}
+
+func (r *fakeRunner) record(command string) {
+	r.mu.Lock()
+	r.commands = append(r.commands, command)
+	r.mu.Unlock()
+	r.changed <- struct{}{}
+}
+
+func (r *fakeRunner) Run(ctx context.Context, cmd Cmd) error {
+	r.record(cmd.Name + " " + cmd.Args[0])
+	for _, arg := range cmd.Args {
+		if out, ok := strings.CutPrefix(arg, "-o="); ok {
+			return os.WriteFile(out, nil, 0o755)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRunner) Start(ctx context.Context, cmd Cmd) (Process, error) {
+	r.record("start " + filepath.Base(cmd.Name))
+	p := &fakeProcess{r: r, signaled: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Signal(os.Interrupt)
+		case <-p.signaled:
+		}
+	}()
+	return p, nil
+}
+
+// waitFor waits until the runner recorded n commands and returns them.
+func (r *fakeRunner) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		r.mu.Lock()
+		commands := append([]string(nil), r.commands...)
+		r.mu.Unlock()
+		if len(commands) >= n {
+			return commands
+		}
+		select {
+		case <-r.changed:
+		case <-timeout:
+			t.Fatalf("timed out waiting for %d commands, got %q", n, commands)
+		}
+	}
+}
+
+type fakeProcess struct {
+	r        *fakeRunner
+	once     sync.Once
+	signaled chan struct{}
+}
+
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.once.Do(func() {
+		p.r.record("signal")
+		close(p.signaled)
+	})
+	return nil
+}
+
+// Wait waits for the process to be signaled, upon which it exits
+// successfully.
+func (p *fakeProcess) Wait() error {
+	<-p.signaled
+	return nil
+}
+
+// testWatch runs the watch loop of c over a MemFS holding a main package in
+// dir, with a fakeRunner and a fakeClock, until the test ends. It returns
+// the MemFS and the path of main.go.
+func testWatch(t *testing.T, dir string, c Config) (*MemFS, string, *fakeRunner, *fakeClock) {
+	t.Helper()
+	fs := NewMemFS()
+	fs.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n"))
+	main := filepath.Join(dir, "main.go")
+	fs.WriteFile(main, []byte("package main\n\nfunc main() {}\n"))
+	runner, clock := newFakeRunner(), newFakeClock()
+	c.Dir, c.FileSource, c.FS, c.Runner, c.Clock = dir, fs, fs, runner, clock
+	if c.Replay == "" {
+		c.Changes = fs.Backend()
+	}
+	c.Stdout, c.Stderr, c.Logf = io.Discard, io.Discard, t.Logf
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, c) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v", err)
+		}
+	})
+	return fs, main, runner, clock
+}
+
+// started are the commands of a watcher until its program started.
+var started = []string{"go env", "go build", "start __gowatch"}
+
+// restarted are the commands of a watcher until it restarted its program
+// after a change.
+var restarted = append(started[:len(started):len(started)], "go build", "signal", "start __gowatch")
+
+func TestWatchRestartsOnChange(t *testing.T) {
+	fs, main, runner, clock := testWatch(t, t.TempDir(), Config{})
+	if got := runner.waitFor(t, len(started)); !reflect.DeepEqual(got, started) {
+		t.Fatalf("commands = %q, want %q", got, started)
+	}
+	fs.WriteFile(main, []byte("package main\n\nfunc main() { println() }\n"))
+	if got := waitAdvancing(t, runner, clock, len(restarted)); !reflect.DeepEqual(got, restarted) {
+		t.Errorf("commands = %q, want %q", got, restarted)
+	}
+}
+
+func TestWatchDebouncesChanges(t *testing.T) {
+	const debounce = 500 * time.Millisecond
+	fs, main, runner, clock := testWatch(t, t.TempDir(), Config{Debounce: debounce})
+	runner.waitFor(t, len(started))
+	for _, body := range []string{"println(1)", "println(2)"} {
+		fs.WriteFile(main, []byte("package main\n\nfunc main() { "+body+" }\n"))
+		clock.advance(debounce / 2)
+	}
+	// Nothing is rebuilt until no file changed for the debounce.
+	time.Sleep(50 * time.Millisecond)
+	runner.mu.Lock()
+	commands := append([]string(nil), runner.commands...)
+	runner.mu.Unlock()
+	if !reflect.DeepEqual(commands, started) {
+		t.Fatalf("commands = %q before the debounce expired, want %q", commands, started)
+	}
+	clock.advance(debounce)
+	if got := runner.waitFor(t, len(restarted)); !reflect.DeepEqual(got, restarted) {
+		t.Errorf("commands = %q, want %q", got, restarted)
+	}
+}
+
+func TestWatchReplaysEvents(t *testing.T) {
+	dir, logDir := t.TempDir(), t.TempDir()
+	replay := filepath.Join(logDir, "events.log")
+	event := fmt.Sprintf(`{"offset":%d,"name":%q,"op":"WRITE"}`+"\n", time.Second, filepath.Join(dir, "main.go"))
+	if err := os.WriteFile(replay, []byte(event), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, runner, clock := testWatch(t, dir, Config{Replay: replay})
+	if got := runner.waitFor(t, len(started)); !reflect.DeepEqual(got, started) {
+		t.Fatalf("commands = %q, want %q", got, started)
+	}
+	// The replayed write is due a second after the watcher started.
+	if got := waitAdvancing(t, runner, clock, len(restarted)); !reflect.DeepEqual(got, restarted) {
+		t.Errorf("commands = %q, want %q", got, restarted)
+	}
+}
+
+// waitAdvancing advances clock until the runner recorded n commands and
+// returns them.
+func waitAdvancing(t *testing.T, runner *fakeRunner, clock *fakeClock, n int) []string {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		runner.mu.Lock()
+		commands := append([]string(nil), runner.commands...)
+		runner.mu.Unlock()
+		if len(commands) >= n {
+			return commands
+		}
+		select {
+		case <-runner.changed:
+		case <-time.After(10 * time.Millisecond):
+			clock.advance(100 * time.Millisecond)
+		case <-timeout:
+			t.Fatalf("timed out waiting for %d commands, got %q", n, strings.Join(commands, ", "))
+		}
+	}
+}
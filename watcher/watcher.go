@@ -1,15 +1,25 @@
 package watcher
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/fsnotify/fsnotify"
@@ -17,69 +27,647 @@ import (
 )
 
 type Config struct {
-	Dir             string
+	Dir string
+	// Package is the main package to build and run, such as ./cmd/api,
+	// relative to Dir. Only the files of its package graph are watched. It
+	// is the package in Dir by default.
+	Package         string
 	AdditionalFiles []string
 	BuildFlags      []string
 	RuntimeArgs     []string
 	Vendor          bool
 	PrintFiles      bool
-	Env             []string
+
+	// AssetFiles are glob patterns of files, such as CSS or templates read
+	// from disk, whose changes do not rebuild or restart the program. They
+	// are reported to OnFileChange and run the OnAssetChange command, with
+	// the path of the file in GOWATCH_FILE.
+	AssetFiles    []string
+	OnAssetChange string
+	// RestartOnly are glob patterns of files, such as configuration files or
+	// templates read when the program starts, whose changes restart the
+	// program without building it again. "**" matches any number of
+	// directories, as in "templates/**".
+	RestartOnly []string
+	// NoRestart are glob patterns of files, such as HTML templates the
+	// program parses on every request, whose changes neither rebuild nor
+	// restart the program. Unlike AssetFiles, they run no command: they are
+	// only reported to OnFileChange and reload the browsers with Proxy.
+	NoRestart []string
+
+	// Cgroup starts the program and the sidecars in their own cgroup v2 on
+	// Linux, so that every process they spawn, even daemonized ones, is
+	// killed when they stop, and reports the memory and CPU time used by
+	// the program in its Status and with UsageInterval. It requires write
+	// access to the cgroup of gowatch and has no effect with a custom
+	// Runner.
+	Cgroup bool
+
+	// Sidecars are helper processes started once, restarted when they exit
+	// and stopped when Run returns, regardless of the builds of the program.
+	Sidecars []Sidecar
+	// Targets are several programs watched together, see Target. The other
+	// settings apply to every target, except for Record, History and
+	// SummaryOut, which get the name of the target before their extension.
+	// The dashboard has a tab per target.
+	Targets []Target
+	// Name tags every line of the output and of the log of the program with
+	// a colored [Name], as the output of the Targets is.
+	Name string
+
+	// Env is added to the environment of the program. It is kept for
+	// compatibility, new configs should use RunEnv.
+	Env []string
+	// BuildEnv is added to the environment of the build and of the package
+	// loading that finds the files to watch, for variables such as GOFLAGS,
+	// GOPRIVATE, CGO_ENABLED or CC. RunEnv is added to the environment of
+	// the program and of the hooks.
+	BuildEnv []string
+	RunEnv   []string
+	// EnvAllowlist, if set, lists the variables of the host environment
+	// that reach the program and the hooks, instead of all of them. Env and
+	// RunEnv are added regardless.
+	EnvAllowlist []string
+	// EnvFromCommand is a command run before every start of the program,
+	// such as "op run --env-file=.env -- env" or a secrets manager CLI. It
+	// prints KEY=VALUE lines or a JSON object of strings that are added to
+	// the environment of the program, so that secrets are not written in
+	// the config.
+	EnvFromCommand string
+	// GoBinary is the go command used to build and to find the files to
+	// watch, "go" from the PATH by default. If GoVersion is set, such as
+	// "1.21" or "go1.21.3", gowatch fails at startup unless the go command
+	// provides that version, after applying the toolchain selection of
+	// go.mod and of GOTOOLCHAIN.
+	GoBinary  string
+	GoVersion string
+	// CacheDir, such as ".gowatch", is a directory relative to Dir that
+	// holds the GOCACHE and GOTMPDIR of the build, so that builds do not
+	// share the build cache with other Go work on the machine and the cache
+	// of the project can be wiped by removing it.
+	CacheDir string
+	// KeepStaleTempDirs disables removing, at startup, the temporary
+	// directories of gowatch processes that were killed before cleaning up.
+	KeepStaleTempDirs bool
+	// Matrix lists os/arch pairs, such as "linux/arm64", that are
+	// cross-compiled with "go build" in the background after every
+	// successful build, to check that they still compile. Only the native
+	// build is run. The binaries are written to MatrixOutput, a text/template
+	// with the GOOS, GOARCH, Name (base name of Dir) and Ext (".exe" on
+	// windows) fields, "bin/{{.GOOS}}_{{.GOARCH}}/{{.Name}}{{.Ext}}" by
+	// default, relative to Dir.
+	Matrix       []string
+	MatrixOutput string
+	// CheckBuilds lists os/arch pairs that are built in the background
+	// after every successful build without keeping the binaries, to report
+	// breakages such as windows only files without delaying the restart.
+	CheckBuilds []string
+
+	// SkipCommentChanges skips the restart when the changes to a Go file
+	// only touch comments or formatting.
+	SkipCommentChanges bool
+	// Debounce delays the restart until no file changed for that long, so
+	// that a burst of changes, such as an editor saving a file in several
+	// writes or formatting several files on save, restarts only once.
+	Debounce time.Duration
+	// RestartDelay delays the rebuild until nothing was written in the
+	// watched directories for that long, including the files that are not
+	// watched, so that code generators writing several files are done
+	// first. Unlike Debounce, it also delays the rebuilds after files were
+	// created or imports changed, and it does not delay manual restarts.
+	RestartDelay time.Duration
+	// SpeculativeBuild starts building on the first change of a burst,
+	// while Debounce waits for the others, and starts the build again on
+	// every change, so that the build is done sooner once the debounce
+	// fires. It has no effect without Debounce, with Build, PreBuild,
+	// AutoTidy, GoBinary or GoVersion, or in the test mode.
+	SpeculativeBuild bool
+	// Progress shows a spinner while building, when stderr is a terminal.
+	// Timings logs how long the scan, stop, build and start phases of every
+	// cycle took.
+	Progress bool
+	Timings  bool
+	// QuietBuild hides the output of successful builds and the progress
+	// messages of the go command, such as module downloads, printing only
+	// the errors of failed builds. RepeatErrors prints the errors again
+	// after build outputs longer than 20 lines, so that they stay visible.
+	QuietBuild   bool
+	RepeatErrors bool
+	// ClearScreen clears the terminal, by writing to Stdout, before every
+	// build, so that the output of the current build and program is at the
+	// top.
+	ClearScreen bool
+	// ResolveModules runs the "go get" and "go mod download" commands that
+	// the go command suggests when a build fails because of a missing module
+	// or go.sum entry, and retries the build once. Otherwise the commands
+	// are only logged.
+	ResolveModules bool
+	// TypeCheck type checks the package of the changed file before
+	// building, and reports its errors without running the build and link
+	// when it does not compile.
+	TypeCheck bool
+	// AutoTidy runs "go mod tidy" before building when the changed file
+	// imports a package that no module of go.mod provides.
+	AutoTidy bool
+
+	// Build replaces the "go build" invocation with a custom command. When
+	// Build is set and Command is empty, gowatch only rebuilds on changes.
+	Build string
+	// Command is run after a successful build instead of the compiled
+	// binary. RuntimeArgs are appended to it.
+	Command string
+	// Proxy is the address, such as ":8081", of a reverse proxy to the
+	// program listening on ProxyTarget, such as ":8080". The proxy adds a
+	// script to HTML pages that reloads them after every restart of the
+	// program and every change of an asset file. While the program is
+	// down, it serves a page saying it is rebuilding or showing the build
+	// errors instead. Its WebSocket endpoint /__gowatch/events streams the
+	// file changes, builds, processes and panics, as JSON objects of the
+	// JSON log format described in the README.
+	Proxy       string
+	ProxyTarget string
+	// EventOrigins are the origins, such as "http://localhost:3000" or
+	// "chrome-extension://abcdefgh", whose pages may connect to the
+	// WebSocket endpoint of the Proxy, "*" allowing any. The pages served
+	// through the proxy and the clients that are not browsers, which send
+	// no origin, always may.
+	EventOrigins []string
+	// WaitForPorts are the ports, such as 8080, the program listens on.
+	// Starting the program waits until the previous one released them, up
+	// to 10 seconds, so that it does not fail with "address already in
+	// use". The port of ProxyTarget is waited for as well.
+	WaitForPorts []int
+	// ReadyCheck is probed after the program starts, every 100ms until it
+	// succeeds, to report the program as ready: "tcp://localhost:8080"
+	// once it accepts connections, "http://localhost:8080/healthz" once it
+	// answers with a 2xx or 3xx status, and "grpc://localhost:9090" once
+	// the grpc.health.v1 service of the plaintext gRPC server reports it
+	// as serving, with the name of a service as path to check that
+	// service instead, such as "grpc://localhost:9090/api.Users".
+	ReadyCheck string
+
+	// ControlAddr is the address of a local HTTP API, such as
+	// "127.0.0.1:9999", with the endpoints /restart, /stop, /pause and
+	// /resume, which take POST requests, and /status and /files, which
+	// return JSON. The Targets share it, see controlServer.
+	ControlAddr string
+
+	// PreBuild commands, such as "go generate ./...", run one after the
+	// other before every build, and PostBuild commands after every
+	// successful build, before the program starts. A failing command
+	// aborts the cycle. The writes of PreBuild commands to watched files
+	// do not start another cycle.
+	PreBuild  []string
+	PostBuild []string
+	// Exec is a command run on every change without building anything, such
+	// as a code generator or a docker build, instead of Build and Command.
+	// Outside of a Go module, only the AdditionalFiles are watched.
+	Exec string
+	// ExcludeDirs are directory names or glob patterns whose files are not
+	// watched. Include and Exclude are regular expressions matched against
+	// the path of every watched file.
+	ExcludeDirs []string
+	Include     []string
+	Exclude     []string
+	// ExcludePatterns are glob patterns of files that are not watched,
+	// matched against the slash separated path relative to Dir. A "**"
+	// element matches any number of directories, a pattern without a slash
+	// matches the base name of the file, such as "*_gen.go", and a pattern
+	// ending with a slash matches directories, such as "testdata/".
+	ExcludePatterns []string
+
+	// GitTracked restricts the watched files to the ones tracked by the
+	// git repository that contains Dir.
+	GitTracked bool
+	// UseGitignore skips the files ignored by the .gitignore files and
+	// .git/info/exclude of the git repository that contains Dir, such as
+	// build artifacts and node_modules. Tracked files are never skipped.
+	// It is on by default when Dir is inside a git repository.
+	UseGitignore *bool
+
+	// Record writes every raw file system event to the given file. Replay
+	// reads such a file and feeds its events through the watcher instead of
+	// watching the file system.
+	Record string
+	Replay string
+
+	// HighlightPanics colors panics and goroutine traces in the program's
+	// stderr, folding runtime frames and emphasizing the first frame within
+	// Dir, which is also reported to OnPanic, and as a panic event of the
+	// JSON log and of a Watcher.
+	HighlightPanics bool
+
+	// Grep and GrepV are regular expressions applied to every line of the
+	// program's output: only lines matching Grep and not matching GrepV
+	// are printed. Panics are never filtered out.
+	Grep  string
+	GrepV string
+	// Highlight rules color the lines of the program's output matching
+	// their pattern. The first matching rule wins.
+	Highlight []HighlightRule
+
+	// DiffBuildErrors compares the errors of a failed build with those of
+	// the previous failure and marks new errors with "+" and fixed ones
+	// with "-".
+	DiffBuildErrors bool
+	// PrettyBuildErrors groups the errors of a failed build by file and
+	// colors their paths and positions. ErrorSnippets also shows the source
+	// line of every error with a caret under the column.
+	PrettyBuildErrors bool
+	ErrorSnippets     bool
+
+	// TUI replaces the plain log output with a full screen dashboard that
+	// shows the build status, the program's output and the last build
+	// errors, and accepts keys to restart, pause or filter the output.
+	TUI bool
+	// LogFormat is LogFormatText, the default, or LogFormatJSON, which
+	// writes the messages of gowatch and its file change, build and process
+	// events as JSON lines to the standard error of gowatch, instead of
+	// calling Logf.
+	LogFormat string
+	// Keys remaps the keys of the dashboard, from an action such as
+	// KeyRestart to a single character.
+	Keys map[string]string
+
+	// OnSuccess and OnFailure are commands run when a build fails after a
+	// successful one, or succeeds after a failed one. The first build counts
+	// as a transition if it fails. The details are passed in the
+	// GOWATCH_STATUS, GOWATCH_TRIGGER, GOWATCH_BUILD_DURATION,
+	// GOWATCH_ERROR_COUNT and GOWATCH_ERROR environment variables.
+	OnSuccess string
+	OnFailure string
+
+	// PauseSignal makes SIGUSR1 toggle pausing restarts, on the platforms
+	// that have it. Changes made while paused are coalesced into a single
+	// rebuild on resume.
+	PauseSignal bool
+
+	// Mode is ModeRun, the default, to build and run the program, or
+	// ModeTest to run "go test" with the TestFlags on the TestPackages,
+	// "./..." by default, on every change instead. The files of the test
+	// packages and their tests are watched.
+	Mode         string
+	TestPackages []string
+	TestFlags    []string
+
+	// KillTimeout is how long the program has to exit once interrupted,
+	// before it is killed. It waits forever by default, except on Windows,
+	// where programs that ignore the CTRL_BREAK event are killed after 5s.
+	KillTimeout time.Duration
+	// StopSignal is the signal, such as "SIGTERM" or "SIGHUP", that stops
+	// the program, an interrupt by default. On Windows, where only
+	// interrupts and kills can be sent, the other signals fall back to an
+	// interrupt.
+	StopSignal string
+
+	// OnFirstFailure selects what happens when the first build or start of
+	// the program fails: FirstFailureWatch (the default) waits for the next
+	// change, FirstFailureRetry tries again every RetryInterval, 2 seconds
+	// by default, until it succeeds or a file changes, and FirstFailureExit
+	// makes Run return the error.
+	OnFirstFailure string
+	RetryInterval  time.Duration
+
+	// RestartOnCrash starts the program again, without building it, when
+	// it exits with an error, so that transient failures such as a port
+	// that is not released yet heal by themselves. It waits CrashBackoff,
+	// 500ms by default, before the first attempt and twice as long before
+	// every next one, and gives up after MaxCrashRestarts attempts in a
+	// row, 5 by default, until a file changes. A program that ran for 10
+	// seconds before crashing starts over with the first attempt.
+	RestartOnCrash   bool
+	MaxCrashRestarts int
+	CrashBackoff     time.Duration
+
+	// RestartOnInterrupt makes an interrupt, such as a Ctrl-C, restart the
+	// program instead of stopping gowatch, which quits on a second interrupt
+	// within a second, or when "q" is entered. The caller must not cancel
+	// the context of Run on interrupts in this mode. The dashboard keeps
+	// quitting on Ctrl-C, as it reads it as a key.
+	RestartOnInterrupt bool
+
+	// CrashDir is a directory, relative to Dir, where a crash-<time>.log
+	// report is written when the program exits with an error on its own.
+	// It has the last CrashOutputSize bytes of the output of the program,
+	// 64KB by default, its command line, the names of its environment
+	// variables and the git commit.
+	CrashDir        string
+	CrashOutputSize int
+
+	// Attach is the pid of a running program, or a file containing it, that
+	// gowatch adopts instead of building and starting the program, until the
+	// first change replaces it.
+	Attach string
+
+	// Once builds and runs the program a single time without watching
+	// files. Run returns once the program exits, with its error.
+	Once bool
+
+	// UsageInterval, if set, logs how many files, directories and kernel
+	// watches are in use, the rate of file events and, with Cgroup, the
+	// resources used by the program at that interval.
+	// gowatch always warns when the inotify watches are about to run out.
+	UsageInterval time.Duration
+
+	// History is a file every build and run cycle is appended to, as JSON
+	// lines. The last HistorySize cycles, 100 by default, are also kept in
+	// memory and shown by the dashboard.
+	History     string
+	HistorySize int
+	// SummaryOut is a file a JSON Summary of the run is written to when Run
+	// returns.
+	SummaryOut string
+
+	// Backend selects how file changes are detected: "fsnotify" (the
+	// default), "poll", which checks the watched files every PollInterval,
+	// "watchman", which subscribes to a running Watchman daemon, or
+	// "notify", which watches directories recursively with the platform's
+	// native APIs. Other backends can be added with RegisterBackend. When
+	// Backend is empty and Dir is on a network or virtualized file system,
+	// such as NFS, sshfs or WSL's drvfs, or PollInterval is set, "poll" is
+	// used.
+	Backend      string
+	PollInterval time.Duration
 
 	// Non serialized fields
+	// Stdin, if set, is the standard input of the program. Input is
+	// forwarded to the running process, and held while it restarts. The
+	// program has no input by default.
+	Stdin          io.Reader                `json:"-"`
 	Stdout, Stderr io.Writer                `json:"-"`
 	OnFileChange   func(file string)        `json:"-"`
 	OnProcessStart func()                   `json:"-"`
 	OnProcessExit  func(err error)          `json:"-"`
+	OnPanic        func(p Panic)            `json:"-"`
 	Logf           func(s string, a ...any) `json:"-"`
+	// OnBuildStart is called before every build. OnBuild is called after
+	// every build with its result. OnBuildOutput is called with its Output
+	// and Err only. OnProcessStart is called once the program started.
+	OnBuildStart  func()                         `json:"-"`
+	OnBuild       func(r BuildResult)            `json:"-"`
+	OnBuildOutput func(output string, err error) `json:"-"`
+
+	// FileSource, Runner and Clock replace the file discovery, process
+	// execution and time source used by the watcher. They default to
+	// implementations backed by go/packages, os/exec and the time package.
+	FileSource FileSource `json:"-"`
+	Runner     Runner     `json:"-"`
+	Clock      Clock      `json:"-"`
+	// FS, which reads the watched files, and Changes, which reports their
+	// changes instead of the Backend, replace the disk. A MemFS provides
+	// both, to run the watcher against an in-memory or remote file system.
+	FS      FS      `json:"-"`
+	Changes Backend `json:"-"`
 }
 
 func Run(ctx context.Context, c Config) error {
-	for _, a := range c.BuildFlags {
-		if isOutputFlag(a) {
-			return fmt.Errorf("-o build flag is disallowed because gowatch manages the go build for you")
-		}
+	if len(c.Targets) > 0 {
+		return runTargets(ctx, c)
+	}
+	return runWatcher(ctx, c, runOptions{})
+}
+
+// runOptions are the settings of a run that are not part of its Config,
+// such as what the Targets share.
+type runOptions struct {
+	// tagged is set once the output is tagged with the Name.
+	tagged bool
+	// controlServer is the control API shared by the Targets.
+	controlServer *controlServer
+	// tuiScreen is the dashboard shared by the Targets, one tab each.
+	tuiScreen *tuiScreen
+	// quit is closed when "q" is entered, read once for the Targets with
+	// RestartOnInterrupt.
+	quit <-chan struct{}
+	// deps are the targets and sidecars that are ready, shared by the
+	// Targets, and dependsOn the ones to wait for before starting.
+	deps      *dependencies
+	dependsOn []string
+}
+
+// runWatcher runs the watcher of c with opts.
+func runWatcher(ctx context.Context, c Config, opts runOptions) error {
+	if c.Name != "" && !opts.tagged {
+		defer c.tagOutput(tag(c.Name, 0, len(c.Name)))()
+	}
+	w, err := newWatcher(c)
+	if err != nil {
+		return err
 	}
+	w.opts = opts
+	return w.run(ctx)
+}
 
-	s := set{}
-	if len(c.AdditionalFiles) > 0 {
-		for _, pattern := range c.AdditionalFiles {
-			matches, err := filepath.Glob(pattern)
+// run runs the watcher until ctx is done, or until the program exits with
+// Once.
+func (w *watcher) run(ctx context.Context) error {
+	c := w.c
+	var err error
+	w.files, err = w.discover()
+	if err != nil {
+		return err
+	}
+	if c.PrintFiles {
+		fmt.Println(strings.Join(w.files.slice(), "\n"))
+		return nil
+	}
+
+	if c.TUI {
+		screen := w.opts.tuiScreen
+		if screen == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			screen, err = newTUIScreen(c.Clock, cancel, c.Keys)
 			if err != nil {
 				return err
 			}
-			s.add(matches...)
+			defer screen.close()
+		}
+		if w.commands == nil {
+			w.commands = make(chan tuiCommand)
 		}
+		w.tui = screen.add(c.Name, w.commands)
+		w.c.Stdout, w.c.Stderr, w.c.Logf = w.tui.writer(), w.tui.writer(), w.tui.logf
+	}
+	w.history = newHistory(w.c)
+	if c.SummaryOut != "" {
+		defer func() {
+			if err := w.history.writeSummary(c.SummaryOut); err != nil {
+				w.c.Logf("error writing summary: %v", err)
+			}
+		}()
+	}
+	w.tui.setHistory(w.history)
+	if c.Stdin != nil {
+		w.stdin = newStdinPump(c.Stdin)
 	}
 
-	var err error
-	if c.Dir == "" {
-		c.Dir, err = os.Getwd()
+	if !c.KeepStaleTempDirs {
+		removeStaleTempDirs(c.Logf)
+	}
+	tmpdir, err := makeTempDir()
+	if err != nil {
+		return err
+	}
+	w.binpath = filepath.Join(tmpdir, "__gowatch")
+	defer os.RemoveAll(tmpdir)
+
+	defer w.startSidecars(ctx)()
+
+	if c.Proxy != "" {
+		w.live, err = newLiveReload(c.Proxy, c.ProxyTarget, c.EventOrigins, c.Clock, w.c.Logf)
 		if err != nil {
-			return fmt.Errorf("os.Getwd: %w", err)
+			return fmt.Errorf("proxy: %w", err)
 		}
+		defer w.live.close()
+		newJSONLog(w.live.events, c.Clock, c.Name).hookEvents(&w.c)
 	}
 
-	goFiles, err := listGoFiles(c.Dir)
-	if err != nil {
-		return fmt.Errorf("error listing go files: %w", err)
+	srv := w.opts.controlServer
+	if srv == nil && c.ControlAddr != "" {
+		srv, err = newControlServer(c.ControlAddr, w.c.Logf)
+		if err != nil {
+			return fmt.Errorf("control: %w", err)
+		}
+		defer srv.close()
+	}
+	if srv != nil {
+		if w.commands == nil {
+			w.commands = make(chan tuiCommand)
+		}
+		w.control = srv.add(c.Name, w.commands, c.Clock, w.history)
+		defer w.control.close()
+		w.control.setFiles(w.files.slice())
 	}
-	s.add(goFiles...)
 
-	if c.PrintFiles {
-		fmt.Println(strings.Join(s.slice(), "\n"))
+	if err := w.opts.deps.wait(ctx, w.opts.dependsOn, w.c.Logf); err != nil {
 		return nil
 	}
+	if c.Once {
+		return w.once(ctx)
+	}
+	return w.watch(ctx)
+}
 
-	tmpdir, err := os.MkdirTemp("", "gowatch")
-	if err != nil {
-		return fmt.Errorf("os.MkdirTemp: %w", err)
+// checkCommand returns an error if the command of setting is set but has no
+// program to run, such as a command made of spaces.
+func checkCommand(setting, command string) error {
+	if command != "" && len(strings.Fields(command)) == 0 {
+		return fmt.Errorf("%s has no program to run: %q", setting, command)
 	}
-	binpath := filepath.Join(tmpdir, "__gowatch")
-	defer os.RemoveAll(tmpdir)
+	return nil
+}
 
+// newWatcher validates c and fills in its defaults.
+func newWatcher(c Config) (*watcher, error) {
+	if _, _, err := keyBindings(c.Keys); err != nil {
+		return nil, err
+	}
+	for _, a := range c.BuildFlags {
+		if isOutputFlag(a) {
+			return nil, fmt.Errorf("-o build flag is disallowed because gowatch manages the go build for you")
+		}
+	}
+	for _, cmd := range []struct{ setting, command string }{
+		{"Build", c.Build},
+		{"Command", c.Command},
+		{"Exec", c.Exec},
+		{"OnSuccess", c.OnSuccess},
+		{"OnFailure", c.OnFailure},
+		{"OnAssetChange", c.OnAssetChange},
+		{"EnvFromCommand", c.EnvFromCommand},
+	} {
+		if err := checkCommand(cmd.setting, cmd.command); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSidecars(c.Sidecars); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if c.Dir == "" {
+		c.Dir, err = os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("os.Getwd: %w", err)
+		}
+	}
+	c.Dir, err = filepath.Abs(c.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Abs: %w", err)
+	}
+	if err := isolateCache(&c); err != nil {
+		return nil, err
+	}
+	if err := pinToolchain(&c); err != nil {
+		return nil, err
+	}
+	if c.FS == nil {
+		c.FS = osFS{}
+	}
+	if (c.Proxy == "") != (c.ProxyTarget == "") {
+		return nil, fmt.Errorf("the proxy needs both a listen address and a target")
+	}
+	if c.Exec != "" && (c.Build != "" || c.Command != "" || c.Mode == ModeTest) {
+		return nil, fmt.Errorf("exec replaces the build and the command, it cannot be used with build, command or the test mode")
+	}
+	switch c.Mode {
+	case "", ModeRun:
+	case ModeTest:
+		if c.Package != "" {
+			return nil, fmt.Errorf("the test mode runs the test packages, it cannot be used with a package")
+		}
+		if len(c.TestPackages) == 0 {
+			c.TestPackages = []string{"./..."}
+		}
+	default:
+		return nil, fmt.Errorf("invalid mode %q, want %s or %s", c.Mode, ModeRun, ModeTest)
+	}
+	if c.FileSource == nil && c.Exec != "" {
+		if _, err := findGoMod(c.Dir); err != nil {
+			c.FileSource = noFiles{}
+		}
+	}
+	if c.FileSource == nil {
+		files := packageFiles{env: c.BuildEnv, tags: tagFlags(c.BuildFlags), vendor: c.Vendor, gobin: c.GoBinary}
+		if c.Package != "" {
+			files.patterns = []string{c.Package}
+		}
+		if c.Mode == ModeTest {
+			files.patterns, files.tests = c.TestPackages, true
+		}
+		c.FileSource = files
+	}
 	if c.Logf == nil {
 		c.Logf = log.Printf
 	}
+	if c.KillTimeout == 0 {
+		c.KillTimeout = defaultKillTimeout
+	}
+	var stopSignal os.Signal = os.Interrupt
+	if c.StopSignal != "" {
+		sig, fallback, err := parseSignal(c.StopSignal)
+		if err != nil {
+			return nil, err
+		}
+		if fallback {
+			c.Logf(color.YellowString("%s cannot be sent on %s, interrupting the program instead", c.StopSignal, runtime.GOOS))
+		}
+		stopSignal = sig
+	}
+	if c.Backend == "" && c.PollInterval > 0 {
+		c.Backend = "poll"
+	}
+	if c.Backend == "" && c.Replay == "" && c.Changes == nil {
+		if fs := networkFS(c.Dir); fs != "" {
+			c.Backend = "poll"
+			if c.PollInterval == 0 {
+				c.PollInterval = networkPollInterval
+			}
+			c.Logf(color.YellowString("%s is on a %s file system, where file events are unreliable, polling every %v instead", c.Dir, fs, c.PollInterval))
+		}
+	}
 	if c.Stdout == nil {
 		c.Stdout = os.Stdout
 	}
@@ -89,154 +677,1277 @@ func Run(ctx context.Context, c Config) error {
 	if c.OnFileChange == nil {
 		c.OnFileChange = func(string) {}
 	}
+	if c.OnBuildStart == nil {
+		c.OnBuildStart = func() {}
+	}
+	if c.OnBuild == nil {
+		c.OnBuild = func(BuildResult) {}
+	}
+	if c.OnBuildOutput == nil {
+		c.OnBuildOutput = func(string, error) {}
+	}
 	if c.OnProcessStart == nil {
 		c.OnProcessStart = func() {}
 	}
 	if c.OnProcessExit == nil {
 		c.OnProcessExit = func(error) {}
 	}
+	if c.OnPanic == nil {
+		c.OnPanic = func(Panic) {}
+	}
+	if c.Runner == nil {
+		c.Runner = execRunner{cgroups: c.Cgroup, killTimeout: c.KillTimeout, stopSignal: stopSignal}
+	}
+	if c.Cgroup {
+		g, err := newCgroup()
+		if err != nil {
+			return nil, fmt.Errorf("cgroup: %w", err)
+		}
+		g.close()
+	}
+	if c.Clock == nil {
+		c.Clock = systemClock{}
+	}
+	if c.MaxCrashRestarts == 0 {
+		c.MaxCrashRestarts = defaultMaxCrashRestarts
+	}
+	if c.CrashBackoff == 0 {
+		c.CrashBackoff = defaultCrashBackoff
+	}
+	switch c.LogFormat {
+	case "", LogFormatText:
+	case LogFormatJSON:
+		if c.TUI {
+			return nil, fmt.Errorf("the dashboard cannot be used with the %s log format", LogFormatJSON)
+		}
+		if c.ClearScreen {
+			return nil, fmt.Errorf("the screen cannot be cleared with the %s log format", LogFormatJSON)
+		}
+		newJSONLog(os.Stderr, c.Clock, c.Name).hook(&c)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, want %s or %s", c.LogFormat, LogFormatText, LogFormatJSON)
+	}
 
-	return (&watcher{
-		c:        c,
-		binpath:  binpath,
-		exitChan: make(chan error, 1),
-	}).watch(ctx, s.slice())
+	f, err := newFilter(c)
+	if err != nil {
+		return nil, err
+	}
+	var ignoreRoot string
+	if c.UseGitignore == nil || *c.UseGitignore {
+		// Outside of a repository there is nothing to ignore.
+		ignoreRoot, _ = gitRoot(c.Dir)
+	}
+	grep, err := compileOptional(c.Grep)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grep pattern: %w", err)
+	}
+	grepV, err := compileOptional(c.GrepV)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grep-v pattern: %w", err)
+	}
+	rules, err := compileHighlightRules(c.Highlight)
+	if err != nil {
+		return nil, err
+	}
+	switch c.OnFirstFailure {
+	case "", FirstFailureWatch, FirstFailureRetry, FirstFailureExit:
+	default:
+		return nil, fmt.Errorf("invalid first failure policy %q, want %s, %s or %s", c.OnFirstFailure, FirstFailureWatch, FirstFailureRetry, FirstFailureExit)
+	}
+	matrix, matrixOutput, err := parseMatrix(c)
+	if err != nil {
+		return nil, err
+	}
+	readyCheck, err := parseReadyCheck(c.ReadyCheck)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		c:            c,
+		stopSignal:   stopSignal,
+		filter:       f,
+		ignoreRoot:   ignoreRoot,
+		exitChan:     make(chan error, 1),
+		grep:         grep,
+		grepV:        grepV,
+		rules:        rules,
+		matrix:       matrix,
+		matrixOutput: matrixOutput,
+		readyCheck:   readyCheck,
+	}, nil
 }
 
 type watcher struct {
-	c        Config
-	binpath  string
-	cmd      *exec.Cmd
-	exitChan chan error
-	env      []string
+	c           Config
+	opts        runOptions
+	filter      *filter
+	ignoreRoot  string // the repository whose ignored files are skipped
+	files       set
+	binpath     string
+	proc        Process
+	exitChan    chan error
+	grep, grepV *regexp.Regexp
+	rules       []highlightRule
+	lastErrors  map[string]bool // keys of the diagnostics of the last failed build
+	tui         *tui
+	commands    chan tuiCommand
+	history     *history
+	trigger     string // file that caused the next restart
+	failing     bool   // whether the last build failed
+	procStarted time.Time
+	lastBuild   BuildResult
+	toolchain   string // go version and GOROOT of the last build
+	// toolchainFiles is the toolchainKey the toolchain was found with.
+	toolchainFiles string
+	// spec is the speculative build in progress, see SpeculativeBuild.
+	spec *speculativeBuild
+	// codeHashes holds the code hash of the watched Go files when
+	// SkipCommentChanges is set.
+	codeHashes map[string][sha256.Size]byte
+	// generated holds the hash of the watched files written by the last
+	// PreBuild commands.
+	generated map[string][sha256.Size]byte
+	// imports holds the sorted imports of the watched Go files.
+	imports map[string]string
+
+	matrix       []matrixTarget
+	stdin        *stdinPump
+	crash        *crashRecord // of the running program, with CrashDir
+	assets       set          // files matched by AssetFiles
+	dirs         set          // directories of the watched files
+	live         *liveReload  // the proxy, with Proxy
+	control      *control     // the control API, with ControlAddr
+	embedded     bool         // run by a Watcher
+	unimported   set          // Go files of AdditionalFiles outside the program
+	restartOnly  set          // files matched by RestartOnly
+	stopSignal   os.Signal    // the StopSignal
+	noRestart    set          // files matched by NoRestart
+	matrixOutput *template.Template
+	cancelMatrix context.CancelFunc // cancels the builds of the matrix
+	hooks        sync.WaitGroup     // running hooks
+	unwatched    set                // files that could not be added to the backend
+	timings      cycleTimings       // of the current cycle
+	readyCheck   *url.URL           // the ReadyCheck
+}
+
+// The modes of a Config.
+const (
+	ModeRun  = "run"
+	ModeTest = "test"
+)
+
+// The OnFirstFailure policies.
+const (
+	FirstFailureWatch = "watch"
+	FirstFailureRetry = "retry"
+	FirstFailureExit  = "exit"
+)
+
+const defaultRetryInterval = 2 * time.Second
+
+const (
+	defaultMaxCrashRestarts = 5
+	defaultCrashBackoff     = 500 * time.Millisecond
+	// maxCrashBackoff caps the wait before restarting a crashed program.
+	maxCrashBackoff = 30 * time.Second
+	// crashStableTime is how long a program must run before crashing for
+	// its restarts to start over.
+	crashStableTime = 10 * time.Second
+)
+
+func (w *watcher) retryInterval() time.Duration {
+	if w.c.RetryInterval > 0 {
+		return w.c.RetryInterval
+	}
+	return defaultRetryInterval
+}
+
+// watchRetryInterval is how often the files that could not be watched are
+// added again.
+const watchRetryInterval = 5 * time.Second
+
+// addFiles adds files and their directories to b. Files are added again when
+// already watched, as they may have been replaced on disk. The ones that
+// fail are logged in a summary and kept in w.unwatched to be retried.
+func (w *watcher) addFiles(b Backend, files set) {
+	var failed []string
+	for key, file := range files {
+		if err := b.Add(file); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", file, err))
+			w.unwatched[key] = file
+			continue
+		}
+		delete(w.unwatched, key)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		w.c.Logf(color.YellowString("could not watch %d files, retrying every %v:\n\t%s", len(failed), watchRetryInterval, strings.Join(failed, "\n\t")))
+	}
+	w.watchDirs(b, files)
+}
+
+// retryUnwatched adds the files that could not be watched again.
+func (w *watcher) retryUnwatched(b Backend) {
+	for key, file := range w.unwatched {
+		if _, ok := w.files[key]; !ok {
+			delete(w.unwatched, key)
+			continue
+		}
+		if err := b.Add(file); err == nil {
+			delete(w.unwatched, key)
+			w.c.Logf("now watching %s", file)
+		}
+	}
 }
 
-func (w *watcher) watch(ctx context.Context, files []string) error {
-	watcher, err := fsnotify.NewWatcher()
+// discover returns the set of files to watch.
+func (w *watcher) discover() (set, error) {
+	files, err := w.listFiles()
 	if err != nil {
-		return fmt.Errorf("fsnotify.NewWatcher: %w", err)
+		return nil, err
 	}
-	defer watcher.Close()
+	s, assets, noRestart, restartOnly := set{}, set{}, set{}, set{}
 	for _, f := range files {
-		err = watcher.Add(f)
+		s.add(f.Path)
+		switch f.Source {
+		case SourceAsset:
+			assets.add(f.Path)
+		case SourceLive:
+			noRestart.add(f.Path)
+		case SourceRestart:
+			restartOnly.add(f.Path)
+		}
+	}
+	w.assets, w.noRestart, w.restartOnly = assets, noRestart, restartOnly
+	w.unimported = unimported(w.c.FileSource, files)
+	return s, nil
+}
+
+// rescan runs the file discovery again and updates the files watched by b
+// to match, reporting whether files were added or removed. Files that were
+// already watched are added again, as they may have been replaced on disk.
+func (w *watcher) rescan(b Backend) (bool, error) {
+	started := w.c.Clock.Now()
+	defer func() { w.timings.scan = w.c.Clock.Now().Sub(started) }()
+	files, err := w.discover()
+	if err != nil {
+		return false, err
+	}
+	var added, removed int
+	for key := range files {
+		if _, ok := w.files[key]; !ok {
+			added++
+		}
+	}
+	for key, file := range w.files {
+		if _, ok := files[key]; ok {
+			continue
+		}
+		b.Remove(file)
+		delete(w.unwatched, key)
+		removed++
+	}
+	w.addFiles(b, files)
+	w.files = files
+	w.control.setFiles(files.slice())
+	w.tui.setUsage(w.usage(b).String())
+	if w.codeHashes != nil {
+		w.hashCode()
+	}
+	w.recordImports()
+	changed := added > 0 || removed > 0
+	if changed {
+		w.c.Logf("rescanned files: %d added, %d removed", added, removed)
+	}
+	return changed, nil
+}
+
+func (w *watcher) watch(ctx context.Context) error {
+	// The events are recorded from the start of the backend, which is when
+	// a replay starts too.
+	var (
+		rec       *recorder
+		recordRaw bool
+		err       error
+	)
+	if w.c.Record != "" {
+		rec, err = newRecorder(w.c.Record, w.c.Clock)
 		if err != nil {
-			return fmt.Errorf("watcher.Add(%q): %w", f, err)
+			return fmt.Errorf("record: %w", err)
 		}
+		defer rec.Close()
+	}
+	b, err := newBackend(w.c)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	if r, ok := b.(rawBackend); ok && rec != nil {
+		r.recordRaw(func(event fsnotify.Event) {
+			if err := rec.record(event); err != nil {
+				w.c.Logf("error recording event: %v", err)
+			}
+		})
+		recordRaw = true
+	}
+	defer w.cancelSpeculative()
+	w.unwatched = set{}
+	w.addFiles(b, w.files)
+	g := newGitWatch(w.c.Dir, b)
+	if w.c.SkipCommentChanges {
+		w.hashCode()
+	}
+	w.recordImports()
+	w.reportUsage(b, 0, 0)
+	var (
+		// retry fires when the files that could not be watched are
+		// added again.
+		retry      <-chan time.Time
+		usageTick  <-chan time.Time
+		usageStart = w.c.Clock.Now()
+		events     int
+	)
+	if w.c.UsageInterval > 0 {
+		usageTick = w.c.Clock.After(w.c.UsageInterval)
 	}
+	defer w.history.finish(ResultStopped, nil)
 
-	err = w.start(ctx)
+	// interrupts receives the interrupts that restart the program with
+	// RestartOnInterrupt, and quit is closed when "q" is entered.
+	var (
+		interrupts    = make(chan os.Signal, 1)
+		quit          <-chan struct{}
+		lastInterrupt time.Time
+	)
+	if w.c.RestartOnInterrupt {
+		signal.Notify(interrupts, os.Interrupt)
+		defer signal.Stop(interrupts)
+		// The dashboard and the embedders of a Watcher, which send
+		// commands, handle the input of the terminal themselves.
+		switch {
+		case w.opts.quit != nil:
+			quit = w.opts.quit
+		case w.tui == nil && !w.embedded && w.c.Stdin == nil:
+			quit = quitInput(os.Stdin)
+		}
+	}
+
+	// firstRetry fires when the program is started again after the first
+	// start failed, with the FirstFailureRetry policy.
+	var firstRetry <-chan time.Time
+	// crashRetry fires when the program is started again after it crashed,
+	// with RestartOnCrash, and crashes counts the attempts in a row.
+	var (
+		crashRetry <-chan time.Time
+		crashes    int
+	)
+	if w.c.Attach != "" {
+		p, err := attach(w.c.Attach, w.c.Clock)
+		if err != nil {
+			return fmt.Errorf("attach: %w", err)
+		}
+		w.adopt(p)
+	} else {
+		err = w.start(ctx)
+	}
 	if err != nil {
 		w.c.OnProcessExit(err)
 		w.c.Logf("error starting binary: %v", err)
+		switch w.c.OnFirstFailure {
+		case FirstFailureExit:
+			return err
+		case FirstFailureRetry:
+			w.c.Logf("retrying in %v", w.retryInterval())
+			firstRetry = w.c.Clock.After(w.retryInterval())
+		default:
+			w.c.Logf("waiting for changes")
+		}
 	}
 
+	var (
+		// rescan fires once the working tree has settled after a branch
+		// switch or after files were created or removed. With dirRescan,
+		// the program is only restarted if the watched files changed.
+		// rescanTrigger is the file whose change called for the rescan, which
+		// is the trigger of the restart that follows it.
+		rescan        <-chan time.Time
+		dirRescan     bool
+		rescanTrigger string
+		// gitOp is the git operation restarts are paused for and pending
+		// reports whether a restart was requested in the meantime.
+		gitOp     string
+		gitOpPoll <-chan time.Time
+		pending   bool
+		// paused is set while restarts are paused from the dashboard or by
+		// a signal, and changed counts the changes made in the meantime.
+		paused  bool
+		changed int
+		// debounce fires once no file changed for Debounce, and rerunOnly
+		// is set if only RestartOnly files changed in the meantime.
+		debounce  <-chan time.Time
+		rerunOnly bool
+		// delay fires once nothing was written for RestartDelay, and
+		// delayRerun is set if the delayed restart needs no build.
+		delay      <-chan time.Time
+		delayRerun bool
+	)
+	restart := func() {
+		debounce, rerunOnly = nil, false
+		delay, delayRerun = nil, false
+		if g != nil {
+			if op := g.operation(); op != "" {
+				if gitOp == "" {
+					w.c.Logf(color.YellowString("git %s in progress, pausing restarts until it completes", op))
+					gitOpPoll = w.c.Clock.After(gitOperationPollInterval)
+				}
+				gitOp, pending = op, true
+				return
+			}
+		}
+		firstRetry = nil
+		crashRetry, crashes = nil, 0
+		err := w.restart(ctx)
+		if err != nil {
+			w.c.OnProcessExit(err)
+			w.c.Logf("error restarting binary: %v", err)
+		}
+	}
+	// rerun restarts the program without building it.
+	rerun := func() {
+		debounce, rerunOnly = nil, false
+		delay, delayRerun = nil, false
+		firstRetry = nil
+		crashRetry, crashes = nil, 0
+		if err := w.rerun(ctx); err != nil {
+			w.c.OnProcessExit(err)
+			w.c.Logf("error restarting binary: %v", err)
+		}
+	}
+	// later restarts the program after RestartDelay, without building it if
+	// only is set and every change since the delay started allows it.
+	later := func(only bool) {
+		if w.c.RestartDelay <= 0 {
+			if only {
+				rerun()
+			} else {
+				restart()
+			}
+			return
+		}
+		delayRerun = only && (delay == nil || delayRerun)
+		delay = w.c.Clock.After(w.c.RestartDelay)
+	}
+	// crashed schedules the next start of the program after it crashed,
+	// or gives up after MaxCrashRestarts attempts.
+	crashed := func() {
+		if crashes >= w.c.MaxCrashRestarts {
+			w.c.Logf(color.YellowString("the program crashed %d times in a row, waiting for changes", crashes+1))
+			return
+		}
+		backoff := min(w.c.CrashBackoff<<crashes, maxCrashBackoff)
+		crashes++
+		w.c.Logf(color.YellowString("restarting in %v, attempt %d of %d", backoff, crashes, w.c.MaxCrashRestarts))
+		crashRetry = w.c.Clock.After(backoff)
+	}
+	togglePause := func() {
+		paused = !paused
+		w.tui.setPaused(paused)
+		w.control.setPaused(paused)
+		if paused {
+			changed = 0
+			w.c.Logf(color.YellowString("paused restarts"))
+			return
+		}
+		w.c.Logf(color.YellowString("resumed restarts, %d changes while paused", changed))
+		if pending && gitOp == "" {
+			pending = false
+			restart()
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	if w.c.PauseSignal && len(pauseSignals) > 0 {
+		signal.Notify(sigs, pauseSignals...)
+		defer signal.Stop(sigs)
+	}
 	for {
+		if len(w.unwatched) > 0 && retry == nil {
+			retry = w.c.Clock.After(watchRetryInterval)
+		}
 		select {
 		case <-ctx.Done():
-			if err == nil && w.cmd != nil {
+			if err == nil && w.proc != nil {
 				err = errors.Join(<-w.exitChan, ctx.Err())
 			}
 			return err
-		case event := <-watcher.Events:
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				w.c.Logf(color.MagentaString("modified file: %v", event.Name))
+		case event := <-b.Events():
+			events++
+			if delay != nil {
+				delay = w.c.Clock.After(w.c.RestartDelay)
+			}
+			if rec != nil && !recordRaw {
+				if err := rec.record(event); err != nil {
+					w.c.Logf("error recording event: %v", err)
+				}
+			}
+			if g != nil && g.owns(event.Name) {
+				if g.changed(b, event.Name) {
+					w.c.Logf(color.MagentaString("git HEAD changed to %v", g.head))
+					rescan, dirRescan, rescanTrigger = w.c.Clock.After(gitSettleTime), false, ""
+				}
+				continue
+			}
+			if rescan != nil {
+				// The branch switch or the creation of files is
+				// still going on, the changes are all picked up by
+				// the coming rebuild, which must happen if a
+				// watched file changed.
+				if _, ok := w.files[pathKey(event.Name)]; ok {
+					dirRescan = false
+				}
+				continue
+			}
+			if _, ok := w.dirs[pathKey(event.Name)]; ok {
+				// Backends that poll report the files created or
+				// removed in a directory as a write of the
+				// directory, which may not be a watched file.
+				rescan, dirRescan, rescanTrigger = w.c.Clock.After(fileSetSettleTime), true, ""
+				continue
+			}
+			_, watched := w.files[pathKey(event.Name)]
+			if change := w.fileSetChange(event, watched); change != "" {
+				w.c.Logf(color.MagentaString("%s: %v", change, event.Name))
 				w.c.OnFileChange(event.Name)
-				err := w.restart(ctx)
-				if err != nil {
-					w.c.OnProcessExit(err)
-					w.c.Logf("error restarting binary: %v", err)
+				w.tui.fileChanged(event.Name)
+				w.cancelSpeculative()
+				rescan, dirRescan, rescanTrigger = w.c.Clock.After(fileSetSettleTime), false, event.Name
+				continue
+			}
+			if !watched {
+				// A file next to the watched ones.
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			switch class := w.classify(event.Name); class {
+			case changeNone:
+			case changeAsset:
+				w.c.Logf(color.MagentaString("modified asset: %v", event.Name))
+				w.c.OnFileChange(event.Name)
+				w.tui.fileChanged(event.Name)
+				w.assetChanged(ctx, event.Name)
+				if w.live != nil {
+					w.live.reload()
+				}
+			case changeNoRestart:
+				w.c.Logf(color.MagentaString("modified file: %v, not restarting", event.Name))
+				w.c.OnFileChange(event.Name)
+				w.tui.fileChanged(event.Name)
+				if w.live != nil {
+					w.live.reload()
+				}
+			case changeImports, changeModule:
+				if class == changeModule {
+					w.c.Logf(color.MagentaString("modified module file: %v", event.Name))
+				} else {
+					w.c.Logf(color.MagentaString("imports changed in %v", event.Name))
+				}
+				w.c.OnFileChange(event.Name)
+				w.tui.fileChanged(event.Name)
+				w.cancelSpeculative()
+				rescan, dirRescan, rescanTrigger = w.c.Clock.After(fileSetSettleTime), false, event.Name
+			case changeRestart, changeBuild:
+				if class == changeRestart {
+					w.c.Logf(color.MagentaString("modified file: %v, restarting without building", event.Name))
+				} else {
+					w.c.Logf(color.MagentaString("modified file: %v", event.Name))
+				}
+				w.c.OnFileChange(event.Name)
+				w.tui.fileChanged(event.Name)
+				w.trigger = event.Name
+				if class == changeBuild {
+					w.cancelSpeculative()
+				}
+				if paused {
+					changed++
+				}
+				if gitOp != "" || paused {
+					pending = true
+					continue
+				}
+				if w.c.Debounce > 0 {
+					// Only restart without building if every change
+					// since the debounce started allows it.
+					rerunOnly = class == changeRestart && (debounce == nil || rerunOnly)
+					debounce = w.c.Clock.After(w.c.Debounce)
+					if class == changeBuild && w.speculative() {
+						w.startSpeculative(ctx)
+					}
+					continue
+				}
+				later(class == changeRestart)
+			}
+		case <-debounce:
+			only := rerunOnly
+			debounce, rerunOnly = nil, false
+			later(only)
+		case <-delay:
+			if delayRerun {
+				rerun()
+				continue
+			}
+			restart()
+		case <-rescan:
+			rescan, w.trigger, rescanTrigger = nil, rescanTrigger, ""
+			changed, err := w.rescan(b)
+			if err != nil {
+				w.c.Logf("error rescanning files: %v", err)
+			}
+			if dirRescan && !changed {
+				continue
+			}
+			if paused {
+				pending = true
+				continue
+			}
+			later(false)
+		case <-gitOpPoll:
+			if op := g.operation(); op != "" {
+				gitOp, gitOpPoll = op, w.c.Clock.After(gitOperationPollInterval)
+				continue
+			}
+			w.c.Logf(color.YellowString("git %s completed, resuming restarts", gitOp))
+			gitOp, gitOpPoll = "", nil
+			if pending && !paused {
+				pending = false
+				if _, err := w.rescan(b); err != nil {
+					w.c.Logf("error rescanning files: %v", err)
+				}
+				restart()
+			}
+		case cmd := <-w.commands:
+			switch cmd {
+			case cmdRestart:
+				w.c.Logf("restarting")
+				w.trigger = ""
+				restart()
+			case cmdTogglePause:
+				togglePause()
+			case cmdPause, cmdResume:
+				if paused != (cmd == cmdPause) {
+					togglePause()
 				}
+			case cmdQuit:
+				w.history.finish(ResultStopped, nil)
+				return w.stop(ctx)
+			}
+		case <-sigs:
+			togglePause()
+		case <-interrupts:
+			now := w.c.Clock.Now()
+			if now.Sub(lastInterrupt) < interruptWindow {
+				w.history.finish(ResultStopped, nil)
+				return w.stop(ctx)
+			}
+			lastInterrupt = now
+			w.c.Logf(color.YellowString("restarting, press Ctrl-C again within %v to quit", interruptWindow))
+			w.trigger = ""
+			restart()
+		case <-quit:
+			w.history.finish(ResultStopped, nil)
+			return w.stop(ctx)
+		case <-firstRetry:
+			firstRetry = nil
+			if err := w.start(ctx); err != nil {
+				w.c.OnProcessExit(err)
+				w.c.Logf("error starting binary: %v, retrying in %v", err, w.retryInterval())
+				firstRetry = w.c.Clock.After(w.retryInterval())
+			}
+		case <-crashRetry:
+			crashRetry, w.trigger = nil, ""
+			if err := w.rerun(ctx); err != nil {
+				w.c.OnProcessExit(err)
+				w.c.Logf("error restarting binary: %v", err)
+				crashed()
 			}
-		case err := <-watcher.Errors:
+		case <-retry:
+			retry = nil
+			w.retryUnwatched(b)
+		case <-usageTick:
+			now := w.c.Clock.Now()
+			w.reportUsage(b, events, now.Sub(usageStart))
+			usageStart, events = now, 0
+			usageTick = w.c.Clock.After(w.c.UsageInterval)
+		case err := <-b.Errors():
 			w.c.Logf("watcher error: %v", err)
 		case err := <-w.exitChan:
-			w.cmd = nil
+			ran := w.c.Clock.Now().Sub(w.procStarted)
+			w.proc = nil
+			w.tui.processExited(err)
+			w.control.processExited(err, false)
+			if err != nil {
+				w.history.finish(ResultCrashed, err)
+			} else {
+				w.history.finish(ResultExited, nil)
+			}
 			w.c.OnProcessExit(err)
-			w.c.Logf("process exited unexpectedly: %v", err)
+			switch {
+			case w.c.Exec != "" && err != nil:
+				w.c.Logf(color.RedString("command failed: %v", err))
+			case w.c.Exec != "":
+				w.c.Logf(color.GreenString("command finished"))
+			case w.c.Mode != ModeTest:
+				w.c.Logf("process exited unexpectedly: %v", err)
+				if err != nil {
+					w.saveCrash(err)
+				}
+			case err != nil:
+				w.c.Logf(color.RedString("tests failed: %v", err))
+			default:
+				w.c.Logf(color.GreenString("tests passed"))
+			}
+			if w.c.RestartOnCrash && err != nil && w.c.Mode != ModeTest {
+				if ran >= crashStableTime {
+					crashes = 0
+				}
+				crashed()
+			}
 		}
 	}
 }
 
+// once builds and runs the program, and waits for it to exit. The program
+// is interrupted when ctx is done.
+func (w *watcher) once(ctx context.Context) error {
+	defer w.hooks.Wait()
+	if err := w.start(ctx); err != nil {
+		return err
+	}
+	if w.proc == nil {
+		return nil
+	}
+	err := <-w.exitChan
+	w.proc = nil
+	w.c.OnProcessExit(err)
+	if err != nil && ctx.Err() == nil && w.c.Mode != ModeTest && w.c.Exec == "" {
+		w.saveCrash(err)
+	}
+	if err != nil {
+		w.history.finish(ResultCrashed, err)
+	} else {
+		w.history.finish(ResultExited, nil)
+	}
+	return err
+}
+
 func (w *watcher) start(ctx context.Context) error {
-	if err := w.build(ctx); err != nil {
+	defer func() { w.timings = cycleTimings{} }()
+	if w.c.Timings {
+		defer func() { w.c.Logf("timings: %v", w.timings) }()
+	}
+	if w.proc != nil {
+		// The running program is stopped only once the new one is built,
+		// but its cycle ends now.
+		w.history.finish(ResultRestarted, nil)
+	}
+	w.history.begin(w.trigger)
+	w.clearScreen()
+	if w.c.AutoTidy {
+		w.tidy(ctx)
+	}
+	if err := w.preBuild(ctx); err != nil {
+		w.history.finish(ResultBuildFailed, err)
+		return err
+	}
+	if w.c.Mode == ModeTest || w.c.Exec != "" {
+		// go test builds the tests itself, and Exec builds nothing.
+		if err := w.halt(ctx); err != nil {
+			return fmt.Errorf("stop: %w", err)
+		}
+		err := w.startBinary(ctx)
+		if err != nil {
+			w.history.finish(ResultCrashed, err)
+			return err
+		}
+		w.c.OnProcessStart()
+		return nil
+	}
+	if w.checkToolchain(ctx) {
+		// The speculative build used the previous toolchain.
+		w.cancelSpeculative()
+	}
+	started := w.c.Clock.Now()
+	err := w.build(ctx)
+	w.timings.build = w.c.Clock.Now().Sub(started)
+	w.history.built(err)
+	if err != nil {
+		w.logBuild(false, false)
+		if w.proc != nil {
+			w.c.Logf(color.YellowString("build failed, the previous program keeps running"))
+		}
 		return fmt.Errorf("build: %w", err)
 	}
+	w.buildMatrix(ctx)
+	if err := w.runCommands(ctx, "post-build", w.c.PostBuild); err != nil {
+		w.history.finish(ResultBuildFailed, err)
+		return err
+	}
+	if w.c.Build != "" && w.c.Command == "" {
+		w.history.finish(ResultBuilt, nil)
+		w.logBuild(false, false)
+		return nil
+	}
+	restarted := w.proc != nil
+	if w.proc != nil {
+		started = w.c.Clock.Now()
+		if err := w.halt(ctx); err != nil {
+			return fmt.Errorf("stop: %w", err)
+		}
+		w.timings.stop = w.c.Clock.Now().Sub(started)
+	}
+	if w.c.Build == "" {
+		if err := os.Rename(w.stagingPath(), w.binpath); err != nil {
+			return fmt.Errorf("os.Rename: %w", err)
+		}
+	}
+	started = w.c.Clock.Now()
+	err = w.startBinary(ctx)
+	w.timings.start = w.c.Clock.Now().Sub(started)
+	if err != nil {
+		w.history.finish(ResultCrashed, err)
+		return err
+	}
 	w.c.OnProcessStart()
-	return w.startBinary(ctx)
+	w.logBuild(true, restarted)
+	if w.live != nil {
+		w.live.reload()
+	}
+	return nil
 }
 
+// restart builds the program again and replaces the running one with it.
+// The running program is only stopped once the build succeeded, so that it
+// keeps running when the build fails.
 func (w *watcher) restart(ctx context.Context) error {
-	if err := w.stop(ctx); err != nil {
-		return fmt.Errorf("stop: %w", err)
-	}
 	if err := w.start(ctx); err != nil {
 		return fmt.Errorf("start: %v", err)
 	}
 	return nil
 }
 
+// clearScreen clears the terminal and moves the cursor to its top if
+// ClearScreen is set. The dashboard draws the whole screen itself.
+func (w *watcher) clearScreen() {
+	if w.c.ClearScreen && w.tui == nil {
+		io.WriteString(w.c.Stdout, "\x1b[H\x1b[2J\x1b[3J")
+	}
+}
+
+// stop stops the running program, ending its cycle.
 func (w *watcher) stop(ctx context.Context) error {
-	if w.cmd == nil {
+	if w.proc == nil {
+		return nil
+	}
+	if err := w.halt(ctx); err != nil {
+		return err
+	}
+	w.history.finish(ResultRestarted, nil)
+	return nil
+}
+
+// halt sends the StopSignal to the running program, if any, and waits for
+// it to exit, killing it after KillTimeout.
+func (w *watcher) halt(ctx context.Context) error {
+	if w.proc == nil {
 		return nil
 	}
 
-	// TODO: call cmd.Process.Kill() if need be and/or timeout.
-	err := w.cmd.Process.Signal(os.Interrupt)
+	err := w.proc.Signal(w.stopSignal)
 	if err != nil && !errors.Is(err, os.ErrProcessDone) {
-		return fmt.Errorf("process.Interrupt: %w", err)
+		return fmt.Errorf("process.Signal: %w", err)
 	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err = <-w.exitChan:
-		var exitErr *exec.ExitError
-		if err != nil && !errors.As(err, &exitErr) {
-			return fmt.Errorf("process.Wait: %w", err)
+	var kill <-chan time.Time
+	if w.c.KillTimeout > 0 {
+		kill = w.c.Clock.After(w.c.KillTimeout)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-kill:
+			kill = nil
+			w.c.Logf(color.YellowString("process did not exit within %v, killing it", w.c.KillTimeout))
+			err := w.proc.Signal(os.Kill)
+			if err != nil && !errors.Is(err, os.ErrProcessDone) {
+				return fmt.Errorf("process.Kill: %w", err)
+			}
+		case err = <-w.exitChan:
+			var exitErr *exec.ExitError
+			if err != nil && !errors.As(err, &exitErr) {
+				return fmt.Errorf("process.Wait: %w", err)
+			}
+			w.proc = nil
+			w.control.processExited(nil, true)
+			return nil
 		}
-		w.cmd = nil
 	}
+}
+
+// rerun starts the program again without building it, unless there is no
+// program to start because the last build failed or nothing was built.
+func (w *watcher) rerun(ctx context.Context) error {
+	if w.failing || w.c.Build != "" && w.c.Command == "" {
+		return w.start(ctx)
+	}
+	if w.c.Build == "" && w.c.Mode != ModeTest && w.c.Exec == "" && w.checkToolchain(ctx) {
+		return w.start(ctx)
+	}
+	if _, err := os.Stat(w.binpath); err != nil && w.c.Build == "" && w.c.Mode != ModeTest && w.c.Exec == "" {
+		return w.start(ctx)
+	}
+	if w.proc != nil {
+		w.history.finish(ResultRestarted, nil)
+	}
+	w.history.begin(w.trigger)
+	w.clearScreen()
+	if err := w.halt(ctx); err != nil {
+		return fmt.Errorf("stop: %w", err)
+	}
+	err := w.startBinary(ctx)
+	if err != nil {
+		w.history.finish(ResultCrashed, err)
+		return err
+	}
+	w.c.OnProcessStart()
 	return nil
 }
 
+// stagingPath is where the program is built before it replaces the running
+// one at binpath.
+func (w *watcher) stagingPath() string {
+	return w.binpath + ".next"
+}
+
+// buildCommand returns the command building the program to out, unless
+// it is a custom Build command.
+func (w *watcher) buildCommand(out string) (string, []string) {
+	name, args := w.goCommand(), append([]string{"build", "-o=" + out}, w.c.BuildFlags...)
+	if w.c.Package != "" {
+		args = append(args, w.c.Package)
+	}
+	if w.c.Build != "" {
+		fields := strings.Fields(w.c.Build)
+		name, args = fields[0], fields[1:]
+	}
+	return name, args
+}
+
 func (w *watcher) build(ctx context.Context) error {
-	args := append([]string{"build", "-o=" + w.binpath}, w.c.BuildFlags...)
-	cmd := exec.CommandContext(ctx, "go", args...)
-	cmd.Dir = w.c.Dir
-	cmd.Stdout = w.c.Stdout
-	cmd.Stderr = w.c.Stderr
-	err := cmd.Run()
+	name, args := w.buildCommand(w.stagingPath())
+	w.c.OnBuildStart()
+	var output bytes.Buffer
+	stdout, stderr := w.c.Stdout, io.MultiWriter(w.c.Stderr, &output)
+	if w.c.DiffBuildErrors || w.c.QuietBuild || w.c.PrettyBuildErrors {
+		stderr = &output
+	}
+	if w.c.QuietBuild {
+		stdout = &output
+	}
+	var combined bytes.Buffer
+	stdout, stderr = io.MultiWriter(stdout, &combined), io.MultiWriter(stderr, &combined)
+	w.tui.buildStarted()
+	w.control.buildStarted()
+	w.live.buildStarted()
+	var sp *spinner
+	if w.c.Progress {
+		sp = startSpinner(w.c.Stderr, w.c.Clock, "building")
+		stdout, stderr = sp.writer(stdout), sp.writer(stderr)
+	}
+	started := w.c.Clock.Now()
+	run := func() error {
+		if ok, err := w.takeSpeculative(stdout, stderr); ok {
+			return err
+		}
+		return w.c.Runner.Run(ctx, Cmd{
+			Name:   name,
+			Args:   args,
+			Dir:    w.c.Dir,
+			Env:    append(os.Environ(), w.c.BuildEnv...),
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+	}
+	var err error
+	if w.c.TypeCheck {
+		err = w.typeCheck(ctx, stderr)
+	}
+	if err == nil {
+		err = run()
+	}
+	sp.Stop()
+	if err != nil && w.resolveModules(ctx, output.String()) {
+		w.c.Logf("retrying the build")
+		output.Reset()
+		combined.Reset()
+		err = run()
+	}
+	result := w.newBuildResult(combined.String(), w.c.Clock.Now().Sub(started), err)
+	w.lastBuild = result
+	w.c.OnBuild(result)
+	w.c.OnBuildOutput(result.Output, err)
+	w.tui.buildFinished(output.String(), err)
+	w.control.buildFinished(output.String(), err)
+	w.live.buildFinished(output.String(), err)
+	w.buildHooks(ctx, result)
+	shown := output.String()
+	if w.c.QuietBuild {
+		shown = ""
+		if err != nil {
+			shown = errorBlock(output.String())
+		}
+	}
+	errs := errorBlock(output.String())
+	if err != nil && w.c.PrettyBuildErrors {
+		errs = formatDiagnostics(errs, w.c.Dir, w.c.ErrorSnippets)
+		if !w.c.DiffBuildErrors {
+			shown = errs
+		}
+	}
+	switch {
+	case w.c.DiffBuildErrors:
+		w.diffBuildErrors(shown, err != nil)
+	case w.c.QuietBuild || w.c.PrettyBuildErrors:
+		io.WriteString(w.c.Stderr, shown)
+	}
+	if err != nil && w.c.RepeatErrors && strings.Count(shown, "\n") > repeatErrorsAfter {
+		fmt.Fprintln(w.c.Stderr, color.New(color.Faint).Sprint("--- build errors ---"))
+		io.WriteString(w.c.Stderr, errs)
+	}
 	if err != nil {
 		return fmt.Errorf("goBuild: %w", err)
 	}
 	return nil
 }
 
-func (w *watcher) startBinary(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, w.binpath, w.c.RuntimeArgs...)
-	cmd.Dir = w.c.Dir
-	cmd.Stdout = w.c.Stdout
-	cmd.Stderr = w.c.Stderr
-	cmd.Env = append(os.Environ(), w.c.Env...)
-	cmd.Cancel = func() error {
-		return cmd.Process.Signal(os.Interrupt)
+// diffBuildErrors prints the captured build output, marking the errors that
+// were not part of the previous failed build and listing the ones that have
+// been fixed since.
+func (w *watcher) diffBuildErrors(output string, failed bool) {
+	if !failed {
+		io.WriteString(w.c.Stderr, output)
+		w.lastErrors = nil
+		return
+	}
+	current := map[string]bool{}
+	var added int
+	for _, line := range strings.SplitAfter(output, "\n") {
+		d, ok := parseDiagnostic(line)
+		switch {
+		case !ok:
+			io.WriteString(w.c.Stderr, line)
+		case w.lastErrors != nil && !w.lastErrors[d.key()]:
+			added++
+			fmt.Fprint(w.c.Stderr, color.RedString("+ ")+line)
+		default:
+			fmt.Fprint(w.c.Stderr, "  "+line)
+		}
+		if ok {
+			current[d.key()] = true
+		}
+	}
+	var fixed int
+	for key := range w.lastErrors {
+		if !current[key] {
+			fixed++
+			fmt.Fprintln(w.c.Stderr, color.GreenString("- "+key))
+		}
+	}
+	if w.lastErrors != nil {
+		w.c.Logf("%d build errors: %d new, %d fixed", len(current), added, fixed)
 	}
+	w.lastErrors = current
+}
 
-	err := cmd.Start()
+func (w *watcher) startBinary(ctx context.Context) error {
+	if err := w.waitForPorts(ctx); err != nil {
+		return err
+	}
+	name, args := w.binpath, w.c.RuntimeArgs
+	switch {
+	case w.c.Mode == ModeTest:
+		name, args = w.goCommand(), append([]string{"test"}, w.c.BuildFlags...)
+		args = append(append(args, w.c.TestFlags...), w.c.TestPackages...)
+	case w.c.Exec != "":
+		fields := strings.Fields(w.c.Exec)
+		name, args = fields[0], append(fields[1:], w.c.RuntimeArgs...)
+	case w.c.Command != "":
+		fields := strings.Fields(w.c.Command)
+		name, args = fields[0], append(fields[1:], w.c.RuntimeArgs...)
+	}
+	// flushers are flushed in order once the process exits, from the first
+	// stage of the output pipeline to the last.
+	var flushers []interface{ Flush() }
+	stdout, stderr := w.c.Stdout, w.c.Stderr
+	if w.c.HighlightPanics {
+		h := newPanicHighlighter(stderr, w.c.Dir, w.c.OnPanic)
+		stderr = h
+		flushers = append(flushers, h)
+	}
+	if w.grep != nil || w.grepV != nil || len(w.rules) > 0 {
+		stdoutLines := newLineProcessor(stdout, w.grep, w.grepV, w.rules)
+		stderrLines := newLineProcessor(stderr, w.grep, w.grepV, w.rules)
+		stdout, stderr = stdoutLines, stderrLines
+		flushers = append([]interface{ Flush() }{stdoutLines, stderrLines}, flushers...)
+	}
+	env := w.runEnv()
+	if w.c.Mode == ModeTest {
+		env = append(env, w.c.BuildEnv...)
+	}
+	if w.c.EnvFromCommand != "" {
+		extra, err := w.commandEnv(ctx)
+		if err != nil {
+			return fmt.Errorf("error running the env command: %w", err)
+		}
+		env = append(env, extra...)
+	}
+	var tail *tailBuffer
+	if w.c.CrashDir != "" {
+		tail = newTailBuffer(w.c.CrashOutputSize)
+		stdout, stderr = io.MultiWriter(stdout, tail), io.MultiWriter(stderr, tail)
+	}
+	cmd := Cmd{
+		Name:   name,
+		Args:   args,
+		Dir:    w.c.Dir,
+		Env:    env,
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if w.stdin != nil {
+		in, err := w.stdin.attach()
+		if err != nil {
+			return fmt.Errorf("os.Pipe: %w", err)
+		}
+		// The process holds its own copy of the read end once started.
+		defer in.Close()
+		cmd.Stdin = in
+	}
+	proc, err := w.c.Runner.Start(ctx, cmd)
+	// Starting a binary that was just written can fail while the file is
+	// still open for writing, retry these with a short backoff.
+	for delay := startRetryDelay; err != nil && isTransientStartError(err) && delay <= maxStartRetryDelay; delay *= 2 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.c.Clock.After(delay):
+		}
+		proc, err = w.c.Runner.Start(ctx, cmd)
+	}
 	if err != nil {
+		if w.stdin != nil {
+			w.stdin.detach()
+		}
 		return fmt.Errorf("cmd.Start: %w", err)
 	}
-	w.cmd = cmd
+	w.proc, w.procStarted = proc, w.c.Clock.Now()
+	w.crash = nil
+	if tail != nil {
+		w.crash = &crashRecord{cmd: cmd, started: w.c.Clock.Now(), output: tail}
+	}
+	w.history.running()
+	w.tui.processStarted()
+	w.control.processStarted(proc)
+	readyCtx, stopReady := context.WithCancel(ctx)
+	if w.readyCheck != nil {
+		go w.waitReady(readyCtx, w.procStarted)
+	}
 	go func() {
-		err := cmd.Wait()
+		err := describeExit(proc.Wait())
+		stopReady()
+		if w.stdin != nil {
+			w.stdin.detach()
+		}
+		for _, f := range flushers {
+			f.Flush()
+		}
 		w.exitChan <- err
 	}()
 	return nil
 }
 
+// goCommand returns the go command to run.
+func (w *watcher) goCommand() string {
+	if w.c.GoBinary != "" {
+		return w.c.GoBinary
+	}
+	return "go"
+}
+
+// runEnv returns the environment of the program.
+func (w *watcher) runEnv() []string {
+	env := os.Environ()
+	if w.c.EnvAllowlist != nil {
+		env = allowedEnv(env, w.c.EnvAllowlist)
+	}
+	env = append(env, w.c.Env...)
+	return append(env, w.c.RunEnv...)
+}
+
+// allowedEnv returns the variables of env whose name is in allowlist.
+func allowedEnv(env, allowlist []string) []string {
+	allowed := map[string]bool{}
+	for _, name := range allowlist {
+		allowed[envKey(name)] = true
+	}
+	var kept []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if allowed[envKey(name)] {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// envKey returns the form of an environment variable name used to compare
+// it with others, ignoring case on Windows as the system does.
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// compileOptional compiles expr, returning a nil Regexp if expr is empty.
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
 func isOutputFlag(f string) bool {
 	return f == "-o" || f == "--o" || strings.HasPrefix(f, "-o=") || strings.HasPrefix(f, "--o=")
 }
 
+// tagFlags returns the -tags flags of the build flags, as -tags=value,
+// which decide the files the go command builds.
+func tagFlags(flags []string) []string {
+	var tags []string
+	for i := 0; i < len(flags); i++ {
+		name, value, ok := strings.Cut(flags[i], "=")
+		if name != "-tags" && name != "--tags" {
+			continue
+		}
+		if !ok && i+1 < len(flags) {
+			i++
+			value = flags[i]
+		}
+		tags = append(tags, "-tags="+value)
+	}
+	return tags
+}
+
 func uniq(slices ...[]string) []string {
 	mp := map[string]struct{}{}
 	final := []string{}
@@ -252,36 +1963,145 @@ func uniq(slices ...[]string) []string {
 	return final
 }
 
-func listGoFiles(wd string) ([]string, error) {
-	s := set{}
+// FileSource lists the files that make up the program in a directory.
+type FileSource interface {
+	Files(dir string) ([]string, error)
+}
+
+// noFiles is the FileSource of Exec outside of a Go module, where only the
+// AdditionalFiles are watched.
+type noFiles struct{}
+
+func (noFiles) Files(string) ([]string, error) { return nil, nil }
+
+// packageFiles is the default FileSource. It lists the go.mod and go.sum
+// files of the module, the go.work file of its workspace, and the Go,
+// embedded and other files, such as assembly or C files, of the package in
+// dir and of every package it imports from the same module, from the other
+// modules of the workspace, from modules replaced by a directory, and from
+// the vendor directory if vendor is set.
+type packageFiles struct {
+	env    []string // added to the environment of the go command
+	tags   []string // the -tags flags of the build
+	vendor bool
+	gobin  string // the go command, "go" if empty
+	// patterns are the packages to load instead of the one in dir, with
+	// their test files if tests is set.
+	patterns []string
+	tests    bool
+}
+
+func (p packageFiles) Files(dir string) ([]string, error) {
+	files, err := p.describe(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	return paths, nil
+}
+
+// describe lists the files like Files, along with their package and how
+// they were found.
+func (p packageFiles) describe(dir string) ([]File, error) {
 	cfg := &packages.Config{
-		Mode: packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
-		Dir:  wd,
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedEmbedFiles |
+			packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+		Dir:        dir,
+		Env:        append(os.Environ(), p.env...),
+		BuildFlags: p.tags,
 	}
-	pkgs, err := packages.Load(cfg, ".")
+	cfg.Tests = p.tests
+	patterns := p.patterns
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
 	if err != nil {
 		return nil, fmt.Errorf("error loading module: %w", err)
 	}
-	filesFromPkg(pkgs[0], pkgs[0].Module.Path, s)
-	return s.slice(), nil
+	if len(pkgs) == 0 || pkgs[0].Module == nil {
+		return nil, fmt.Errorf("no packages of a module match %s", strings.Join(patterns, " "))
+	}
+	var vendorDir string
+	if p.vendor {
+		vendorDir = filepath.Join(pkgs[0].Module.Dir, "vendor")
+	}
+	var files []File
+	mod := pkgs[0].Module
+	if mod.GoMod != "" {
+		files = appendModuleFiles(files, mod.GoMod, "go.sum")
+	}
+	if work := goWork(dir, p.gobin, p.env); work != "" {
+		files = appendModuleFiles(files, work, "go.work.sum")
+	}
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		// The generated main package of a test binary has no files to
+		// watch.
+		if pkg.Module == nil || strings.HasSuffix(pkg.ID, ".test") {
+			continue
+		}
+		filesFromPkg(pkg, pkg.Module.Path, vendorDir, seen, &files)
+	}
+	// The go.mod files of the other workspace modules and of the modules
+	// replaced by a directory that the program imports.
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		if seen[pkg.ID] && localModule(pkg.Module) && pkg.Module.GoMod != "" {
+			files = appendModuleFiles(files, pkg.Module.GoMod, "go.sum")
+		}
+	})
+	// The test variant of a package shares its files.
+	listed := map[string]bool{}
+	unique := files[:0]
+	for _, f := range files {
+		if !listed[f.Path] {
+			listed[f.Path] = true
+			unique = append(unique, f)
+		}
+	}
+	return unique, nil
 }
 
-func filesFromPkg(pkg *packages.Package, prefix string, s set) {
-	s.add(pkg.GoFiles...)
+func filesFromPkg(pkg *packages.Package, prefix, vendorDir string, seen map[string]bool, files *[]File) {
+	if seen[pkg.ID] {
+		return
+	}
+	seen[pkg.ID] = true
+	source := SourcePackage
+	if vendorDir != "" && len(pkg.GoFiles) > 0 && isWithin(pkg.GoFiles[0], vendorDir) {
+		source = SourceVendor
+	}
+	for _, f := range pkg.GoFiles {
+		*files = append(*files, File{Path: f, Source: source, Package: pkg.PkgPath})
+	}
+	for _, f := range pkg.EmbedFiles {
+		*files = append(*files, File{Path: f, Source: SourceEmbed, Package: pkg.PkgPath})
+	}
+	for _, f := range pkg.OtherFiles {
+		*files = append(*files, File{Path: f, Source: SourceOther, Package: pkg.PkgPath})
+	}
 	for importPath, innerPkg := range pkg.Imports {
-		if !strings.HasPrefix(importPath, prefix) {
+		vendored := vendorDir != "" && len(innerPkg.GoFiles) > 0 && isWithin(innerPkg.GoFiles[0], vendorDir)
+		if !inModule(importPath, prefix) && !vendored && !localModule(innerPkg.Module) {
 			continue
 		}
-		filesFromPkg(innerPkg, prefix, s)
+		filesFromPkg(innerPkg, prefix, vendorDir, seen, files)
 	}
 }
 
-type set map[string]struct{}
+// set is a set of paths keyed by pathKey, so that the same file is only
+// stored once regardless of the casing it was found with.
+type set map[string]string
 
 // addSlice adds each element of es to s.
 func (s set) add(es ...string) {
 	for _, e := range es {
-		s[e] = struct{}{}
+		if _, ok := s[pathKey(e)]; !ok {
+			s[pathKey(e)] = e
+		}
 	}
 }
 
@@ -289,8 +2109,8 @@ func (s set) add(es ...string) {
 // in any particular order.
 func (s set) slice() []string {
 	es := make([]string, 0, len(s))
-	for k := range s {
-		es = append(es, k)
+	for _, e := range s {
+		es = append(es, e)
 	}
 	return es
 }
@@ -0,0 +1,245 @@
+package watcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchmanBackend is a Backend that subscribes to a running Watchman daemon
+// through its JSON socket protocol. Watchman watches whole project roots
+// recursively, so only events for added files and for the files directly
+// inside added directories are reported.
+type watchmanBackend struct {
+	conn     net.Conn
+	enc      *json.Encoder
+	resp     chan watchmanResponse
+	readDone chan struct{}
+	events   chan fsnotify.Event
+	errs     chan error
+	done     chan struct{}
+	closeMu  sync.Once
+
+	cmdMu sync.Mutex // serializes commands and their responses
+
+	mu    sync.Mutex
+	files map[string]string // pathKey to the added path
+	roots map[string]struct{}
+}
+
+type watchmanResponse struct {
+	Error           string         `json:"error"`
+	Watch           string         `json:"watch"`
+	Clock           string         `json:"clock"`
+	Sockname        string         `json:"sockname"`
+	UnixDomain      string         `json:"unix_domain"`
+	Unilateral      bool           `json:"unilateral"`
+	Log             string         `json:"log"`
+	Subscription    string         `json:"subscription"`
+	Root            string         `json:"root"`
+	IsFreshInstance bool           `json:"is_fresh_instance"`
+	Files           []watchmanFile `json:"files"`
+}
+
+type watchmanFile struct {
+	Name   string `json:"name"`
+	Exists bool   `json:"exists"`
+	New    bool   `json:"new"`
+}
+
+const watchmanSubscription = "gowatch"
+
+func newWatchmanBackend() (*watchmanBackend, error) {
+	sock, err := watchmanSockname()
+	if err != nil {
+		return nil, fmt.Errorf("watchman: %w", err)
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("watchman: %w", err)
+	}
+	b := &watchmanBackend{
+		conn:     conn,
+		enc:      json.NewEncoder(conn),
+		resp:     make(chan watchmanResponse),
+		readDone: make(chan struct{}),
+		events:   make(chan fsnotify.Event),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+		files:    map[string]string{},
+		roots:    map[string]struct{}{},
+	}
+	go b.read()
+	return b, nil
+}
+
+// watchmanSockname returns the path of the Watchman socket, starting the
+// daemon if needed.
+func watchmanSockname() (string, error) {
+	if sock := os.Getenv("WATCHMAN_SOCK"); sock != "" {
+		return sock, nil
+	}
+	out, err := exec.Command("watchman", "--output-encoding=json", "--no-pretty", "get-sockname").Output()
+	if err != nil {
+		return "", fmt.Errorf("get-sockname: %w", err)
+	}
+	var r watchmanResponse
+	if err := json.Unmarshal(out, &r); err != nil {
+		return "", fmt.Errorf("get-sockname: %w", err)
+	}
+	if r.Error != "" {
+		return "", fmt.Errorf("get-sockname: %s", r.Error)
+	}
+	if r.UnixDomain != "" {
+		return r.UnixDomain, nil
+	}
+	if r.Sockname == "" {
+		return "", errors.New("get-sockname: no socket returned")
+	}
+	return r.Sockname, nil
+}
+
+func (b *watchmanBackend) Add(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	dir := abs
+	if fi, err := os.Stat(abs); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		dir = filepath.Dir(abs)
+	}
+	r, err := b.command("watch-project", dir)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.files[pathKey(abs)] = abs
+	_, subscribed := b.roots[pathKey(r.Watch)]
+	b.roots[pathKey(r.Watch)] = struct{}{}
+	b.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+	clock, err := b.command("clock", r.Watch)
+	if err != nil {
+		return err
+	}
+	_, err = b.command("subscribe", r.Watch, watchmanSubscription, map[string]any{
+		"since":      clock.Clock,
+		"expression": []string{"type", "f"},
+		"fields":     []string{"name", "exists", "new"},
+	})
+	return err
+}
+
+func (b *watchmanBackend) Remove(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	delete(b.files, pathKey(abs))
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *watchmanBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *watchmanBackend) Errors() <-chan error          { return b.errs }
+
+func (b *watchmanBackend) Close() error {
+	var err error
+	b.closeMu.Do(func() {
+		close(b.done)
+		err = b.conn.Close()
+	})
+	return err
+}
+
+// command sends a command to Watchman and waits for its response.
+func (b *watchmanBackend) command(args ...any) (watchmanResponse, error) {
+	b.cmdMu.Lock()
+	defer b.cmdMu.Unlock()
+	if err := b.enc.Encode(args); err != nil {
+		return watchmanResponse{}, fmt.Errorf("watchman %v: %w", args[0], err)
+	}
+	select {
+	case r := <-b.resp:
+		if r.Error != "" {
+			return r, fmt.Errorf("watchman %v: %s", args[0], r.Error)
+		}
+		return r, nil
+	case <-b.readDone:
+		return watchmanResponse{}, fmt.Errorf("watchman %v: connection closed", args[0])
+	}
+}
+
+// read decodes every PDU sent by Watchman, dispatching command responses to
+// the pending command and subscription updates as events.
+func (b *watchmanBackend) read() {
+	defer close(b.readDone)
+	dec := json.NewDecoder(b.conn)
+	for {
+		var r watchmanResponse
+		if err := dec.Decode(&r); err != nil {
+			select {
+			case <-b.done:
+			case b.errs <- fmt.Errorf("watchman: %w", err):
+			}
+			return
+		}
+		if !r.Unilateral && r.Subscription == "" && r.Log == "" {
+			select {
+			case <-b.done:
+				return
+			case b.resp <- r:
+			}
+			continue
+		}
+		if r.Subscription != watchmanSubscription || r.IsFreshInstance {
+			continue
+		}
+		for _, event := range b.translate(r) {
+			select {
+			case <-b.done:
+				return
+			case b.events <- event:
+			}
+		}
+	}
+}
+
+// translate turns the files of a subscription update into events for the
+// files that were added to the backend and the files of added directories.
+func (b *watchmanBackend) translate(r watchmanResponse) []fsnotify.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []fsnotify.Event
+	for _, f := range r.Files {
+		path := filepath.Join(r.Root, filepath.FromSlash(f.Name))
+		name, ok := b.files[pathKey(path)]
+		if _, inDir := b.files[pathKey(filepath.Dir(path))]; !ok && inDir {
+			name, ok = path, true
+		}
+		if !ok {
+			continue
+		}
+		op := fsnotify.Write
+		switch {
+		case !f.Exists:
+			op = fsnotify.Remove
+		case f.New:
+			op = fsnotify.Create
+		}
+		events = append(events, fsnotify.Event{Name: name, Op: op})
+	}
+	return events
+}
@@ -0,0 +1,237 @@
+package watcher
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// eventsPath is the WebSocket endpoint of the proxy that streams the file
+// changes, builds, processes and panics of the watcher. Every message is a
+// text frame holding a JSON object of the JSON log format, such as
+//
+//	{"time":"2023-10-01T12:00:00Z","event":"build_failed","durationMs":420,"error":"./main.go:3:1: syntax error"}
+//
+// with the fields
+//
+//	time        the RFC 3339 time of the event
+//	name        the Config.Name of the program, if set
+//	event       file_changed, build_started, build_succeeded,
+//	            build_failed, process_started, process_exited or panic
+//	file        the changed file of file_changed, the file of the top
+//	            frame within Dir of panic
+//	durationMs  how long the build of build_succeeded and build_failed
+//	            took
+//	error       the first error of build_failed, why the program of
+//	            process_exited exited unless it exited successfully
+//	msg         the message of panic
+//	line        the line of file of panic
+//	function    the function of the frame of panic
+//	diagnostics the errors of build_failed, as objects with file, line,
+//	            column and message
+//	binaryPath  the built program of build_succeeded, unless Build
+//	            replaces the go build
+//	gitSHA      the commit checked out when the build ran, in a git
+//	            repository
+//
+// Fields without a value are omitted. Clients only send control frames.
+// Browsers may only connect from the pages served through the proxy and
+// from the EventOrigins.
+const eventsPath = "/__gowatch/events"
+
+// websocketGUID is appended to the key of a WebSocket handshake to accept
+// it, see RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The opcodes of the WebSocket frames.
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// eventStream sends every JSON object written to it to the WebSocket
+// clients of eventsPath. Events are dropped for the clients that are too far
+// behind.
+type eventStream struct {
+	origins []string // the EventOrigins
+
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+}
+
+func newEventStream(origins []string) *eventStream {
+	return &eventStream{origins: origins, clients: map[chan []byte]bool{}}
+}
+
+func (s *eventStream) Write(p []byte) (int, error) {
+	msg := []byte(strings.TrimSuffix(string(p), "\n"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.clients {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (s *eventStream) serve(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "this endpoint only serves WebSocket connections", http.StatusUpgradeRequired)
+		return
+	}
+	if !s.allowed(r) {
+		http.Error(w, "cross-origin WebSocket connections are not allowed, see EventOrigins", http.StatusForbidden)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ch := make(chan []byte, eventBuffer)
+	s.mu.Lock()
+	s.clients[ch] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+	var mu sync.Mutex // of the writes to rw
+	write := func(op byte, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return writeFrame(rw.Writer, op, payload)
+	}
+	// The client only sends control frames, and is gone once it closes the
+	// connection or sends a close frame.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			op, payload, err := readFrame(rw.Reader)
+			if err != nil || op == opClose {
+				write(opClose, nil)
+				return
+			}
+			if op == opPing {
+				write(opPong, payload)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-r.Context().Done():
+			return
+		case msg := <-ch:
+			if err := write(opText, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// allowed reports whether the page r comes from, if any, may connect: the
+// pages served through the proxy and the ones of the EventOrigins only, so
+// that other web sites cannot read the events.
+func (s *eventStream) allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range s.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFrame writes a single unmasked frame, as a server sends them.
+func writeFrame(w *bufio.Writer, op byte, payload []byte) error {
+	w.WriteByte(0x80 | op)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+	return w.Flush()
+}
+
+// maxFramePayload bounds the frames read from clients, which only send
+// control frames of up to 125 bytes.
+const maxFramePayload = 1 << 16
+
+// readFrame reads a frame sent by a client, unmasking its payload.
+func readFrame(r *bufio.Reader) (op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	op = head[0] & 0x0F
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var n16 uint16
+		if err := binary.Read(r, binary.BigEndian, &n16); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(n16)
+	case 127:
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return 0, nil, err
+		}
+	}
+	if n > maxFramePayload {
+		return 0, nil, errors.New("frame too large")
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// goWork returns the go.work file of the workspace dir belongs to, or "" if
+// it is not in one. gobin is the go command, "go" if empty.
+func goWork(dir, gobin string, env []string) string {
+	if gobin == "" {
+		gobin = "go"
+	}
+	cmd := exec.Command(gobin, "env", "GOWORK")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	work := strings.TrimSpace(string(out))
+	if work == "off" {
+		return ""
+	}
+	return work
+}
+
+// localModule reports whether the packages of m are edited along with the
+// program: m is a module of the workspace or is replaced by a directory.
+// Their files are watched like the ones of the main module.
+func localModule(m *packages.Module) bool {
+	return m != nil && (m.Main || m.Replace != nil && m.Replace.Version == "")
+}
+
+// appendModuleFiles appends the module file at path, a go.mod or go.work
+// file, and its sum file to files if it exists.
+func appendModuleFiles(files []File, path, sum string) []File {
+	files = append(files, File{Path: path, Source: SourceModule})
+	sum = filepath.Join(filepath.Dir(path), sum)
+	if _, err := os.Stat(sum); err == nil {
+		files = append(files, File{Path: sum, Source: SourceModule})
+	}
+	return files
+}